@@ -10,12 +10,142 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var goos string
 var goarch string
 
+// flags shared by all commands that connect to elasticsearch
+var elasticsearchFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "elasticsearch-endpoint",
+		Usage: "The elasticsearch server endpoint. Multiple cluster nodes can be specified as a comma separated list",
+		Value: "http://elasticsearch:9200",
+	},
+	&cli.StringFlag{
+		Name:  "elasticsearch-index",
+		Usage: "The elasticsearch index to store documents in",
+		Value: "lodestone",
+	},
+	&cli.StringFlag{
+		Name:  "elasticsearch-folder-index",
+		Usage: "The elasticsearch index to store folders in. Defaults to the document index name with a '-folders' suffix",
+		Value: "",
+	},
+	&cli.StringFlag{
+		Name:  "elasticsearch-mapping",
+		Usage: "Path to elasticsearch mapping file. Can be used to override static/document-processor/settings.json",
+		Value: "",
+	},
+	&cli.StringFlag{
+		Name:   "elasticsearch-username",
+		Usage:  "Username for elasticsearch basic authentication",
+		EnvVar: "ELASTICSEARCH_USERNAME",
+	},
+	&cli.StringFlag{
+		Name:   "elasticsearch-password",
+		Usage:  "Password for elasticsearch basic authentication",
+		EnvVar: "ELASTICSEARCH_PASSWORD",
+	},
+	&cli.StringFlag{
+		Name:   "elasticsearch-api-key",
+		Usage:  "Base64 encoded elasticsearch API key, cannot be used with username & password",
+		EnvVar: "ELASTICSEARCH_API_KEY",
+	},
+	&cli.StringFlag{
+		Name:   "elasticsearch-cloud-id",
+		Usage:  "Elastic Cloud deployment ID, overrides the elasticsearch endpoint",
+		EnvVar: "ELASTICSEARCH_CLOUD_ID",
+	},
+	&cli.StringFlag{
+		Name:   "elasticsearch-ca-cert",
+		Usage:  "Path to a PEM encoded CA certificate, used to verify the elasticsearch server certificate",
+		EnvVar: "ELASTICSEARCH_CA_CERT",
+	},
+	&cli.BoolFlag{
+		Name:   "elasticsearch-insecure-skip-verify",
+		Usage:  "Do not verify the elasticsearch server certificate",
+		EnvVar: "ELASTICSEARCH_INSECURE_SKIP_VERIFY",
+	},
+	&cli.StringFlag{
+		Name:   "elasticsearch-retry-on-status",
+		Usage:  "Comma separated list of HTTP status codes that should be retried",
+		EnvVar: "ELASTICSEARCH_RETRY_ON_STATUS",
+		Value:  "502,503,504",
+	},
+	&cli.IntFlag{
+		Name:   "elasticsearch-max-retries",
+		Usage:  "Maximum number of times a failed elasticsearch request is retried",
+		EnvVar: "ELASTICSEARCH_MAX_RETRIES",
+		Value:  3,
+	},
+	&cli.BoolFlag{
+		Name:   "elasticsearch-discover-nodes",
+		Usage:  "Discover all elasticsearch cluster nodes on startup, and distribute requests across them",
+		EnvVar: "ELASTICSEARCH_DISCOVER_NODES",
+	},
+}
+
+func parseElasticsearchConfig(c *cli.Context) (document.ElasticsearchConfig, error) {
+	retryOnStatus := []int{}
+	for _, statusStr := range strings.Split(c.String("elasticsearch-retry-on-status"), ",") {
+		statusStr = strings.TrimSpace(statusStr)
+		if statusStr == "" {
+			continue
+		}
+		status, err := strconv.Atoi(statusStr)
+		if err != nil {
+			return document.ElasticsearchConfig{}, fmt.Errorf("elasticsearch: invalid retry status code %q", statusStr)
+		}
+		retryOnStatus = append(retryOnStatus, status)
+	}
+
+	addresses := []string{}
+	for _, address := range strings.Split(c.String("elasticsearch-endpoint"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+
+	elasticsearchConfig := document.ElasticsearchConfig{
+		Addresses:          addresses,
+		Index:              c.String("elasticsearch-index"),
+		FolderIndex:        c.String("elasticsearch-folder-index"),
+		MappingOverride:    c.String("elasticsearch-mapping"),
+		Username:           c.String("elasticsearch-username"),
+		Password:           c.String("elasticsearch-password"),
+		APIKey:             c.String("elasticsearch-api-key"),
+		CloudID:            c.String("elasticsearch-cloud-id"),
+		CACertPath:         c.String("elasticsearch-ca-cert"),
+		InsecureSkipVerify: c.Bool("elasticsearch-insecure-skip-verify"),
+		RetryOnStatus:      retryOnStatus,
+		MaxRetries:         c.Int("elasticsearch-max-retries"),
+		DiscoverNodes:      c.Bool("elasticsearch-discover-nodes"),
+	}
+	return elasticsearchConfig, elasticsearchConfig.Validate()
+}
+
+func parseTikaConfig(c *cli.Context) document.TikaConfig {
+	endpoints := []string{}
+	for _, endpoint := range strings.Split(c.String("tika-endpoint"), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return document.TikaConfig{
+		Endpoints:           endpoints,
+		Balancing:           c.String("tika-balancing"),
+		Timeout:             c.Duration("tika-timeout"),
+		MaxRetries:          c.Int("tika-max-retries"),
+		RetryBackoff:        c.Duration("tika-retry-backoff"),
+		HealthCheckInterval: c.Duration("tika-health-check-interval"),
+	}
+}
+
 func main() {
 	app := &cli.App{
 		Name:     "lodestone-document-processor",
@@ -63,10 +193,15 @@ func main() {
 						logrus.SetLevel(logrus.InfoLevel)
 					}
 
+					elasticsearchConfig, err := parseElasticsearchConfig(c)
+					if err != nil {
+						return err
+					}
+
 					var listenClient listen.Interface
 
 					listenClient = new(listen.AmqpListen)
-					err := listenClient.Init(processorLogger, map[string]string{
+					err = listenClient.Init(processorLogger, map[string]string{
 						"amqp-url": c.String("amqp-url"),
 						"exchange": c.String("amqp-exchange"),
 						"queue":    c.String("amqp-queue"),
@@ -76,16 +211,50 @@ func main() {
 					}
 					defer listenClient.Close()
 
-					documentProcessor, err := document.CreateDocumentProcessor(
-						processorLogger,
-						c.String("api-endpoint"),
-						c.String("storage-thumbnail-bucket"),
-						c.String("tika-endpoint"),
-						c.String("elasticsearch-endpoint"),
-						c.String("elasticsearch-index"),
-						c.String("elasticsearch-mapping"),
-						c.String("ocr-language"),
-					)
+					documentProcessor, err := document.CreateDocumentProcessor(processorLogger, document.DocumentProcessorConfig{
+						ApiEndpoint:            c.String("api-endpoint"),
+						StorageThumbnailBucket: c.String("storage-thumbnail-bucket"),
+						Tika:                   parseTikaConfig(c),
+						Elasticsearch:          elasticsearchConfig,
+						Ocr: document.OcrConfig{
+							LanguageOverride:   c.String("ocr-language"),
+							RulesPath:          c.String("ocr-rules"),
+							Fallback:           c.Bool("ocr-fallback"),
+							FallbackMinQuality: c.Float64("ocr-fallback-min-quality"),
+						},
+						MetaRawInclude:      c.StringSlice("meta-raw-include"),
+						MetaRawExclude:      c.StringSlice("meta-raw-exclude"),
+						MetadataMappingPath: c.String("metadata-mapping"),
+						TagRulesPath:        c.String("tag-rules"),
+						ContentLimits: document.ContentLimits{
+							TikaMaxBytes:    c.Int64("tika-max-bytes"),
+							MaxIndexedChars: c.Int("max-indexed-chars"),
+							ChunkSize:       c.Int("content-chunk-size"),
+						},
+						DetectLanguages:  c.Bool("detect-language"),
+						NativeExtraction: c.Bool("native-extraction"),
+						Entities: document.EntityConfig{
+							Dates:    c.Bool("extract-dates"),
+							Amounts:  c.Bool("extract-amounts"),
+							Emails:   c.Bool("extract-emails"),
+							Phones:   c.Bool("extract-phones"),
+							URLs:     c.Bool("extract-urls"),
+							Accounts: c.Bool("extract-accounts"),
+						},
+						Classification: document.ClassificationConfig{
+							Enabled:   c.Bool("classify"),
+							RulesPath: c.String("category-rules"),
+						},
+						Summary: document.SummaryConfig{
+							Sentences:       c.Int("summary-sentences"),
+							MaxChars:        c.Int("summary-max-chars"),
+							MinContentChars: c.Int("summary-min-content-chars"),
+						},
+						Keywords: document.KeywordConfig{
+							Count:          c.Int("auto-keywords"),
+							MinOccurrences: c.Int("auto-keywords-min-occurrences"),
+						},
+					})
 
 					if err != nil {
 						return err
@@ -94,7 +263,7 @@ func main() {
 					return listenClient.Subscribe(documentProcessor.Process)
 				},
 
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:  "api-endpoint",
 						Usage: "The api server endpoint",
@@ -108,30 +277,156 @@ func main() {
 
 					&cli.StringFlag{
 						Name:  "tika-endpoint",
-						Usage: "The tika server endpoint",
+						Usage: "The tika server endpoint. Multiple tika servers can be specified as a comma separated list",
 						Value: "http://tika:9998",
 					},
 					&cli.StringFlag{
-						Name:  "elasticsearch-endpoint",
-						Usage: "The elasticsearch server endpoint",
-						Value: "http://elasticsearch:9200",
+						Name:  "tika-balancing",
+						Usage: "How documents are distributed across multiple tika servers: 'round-robin' or 'least-busy'",
+						Value: "round-robin",
 					},
-					&cli.StringFlag{
-						Name:  "elasticsearch-index",
-						Usage: "The elasticsearch index to store documents in",
-						Value: "lodestone",
+					&cli.DurationFlag{
+						Name:  "tika-timeout",
+						Usage: "Maximum time tika may spend processing a single document",
+						Value: 5 * time.Minute,
+					},
+					&cli.IntFlag{
+						Name:  "tika-max-retries",
+						Usage: "Maximum number of times a tika request is retried when the tika server is unavailable",
+						Value: 3,
+					},
+					&cli.DurationFlag{
+						Name:  "tika-retry-backoff",
+						Usage: "Delay before retrying a failed tika request, doubled for every retry",
+						Value: time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "tika-health-check-interval",
+						Usage: "How often unavailable tika servers are health checked. Documents are not processed while no tika server is available",
+						Value: 10 * time.Second,
+					},
+
+					&cli.Int64Flag{
+						Name:  "tika-max-bytes",
+						Usage: "Only send the first bytes of larger files to Tika (0 for unlimited)",
+						Value: 0,
+					},
+					&cli.IntFlag{
+						Name:  "max-indexed-chars",
+						Usage: "Truncate document content to this many characters (0 for unlimited). Documents larger than the elasticsearch highlight limit cannot be highlighted",
+						Value: 0,
+					},
+					&cli.IntFlag{
+						Name:  "content-chunk-size",
+						Usage: "Split the content of larger documents into chunk records of this many characters (0 to disable)",
+						Value: 0,
+					},
+
+					&cli.BoolFlag{
+						Name:  "native-extraction",
+						Usage: "Extract plain text, markdown, csv, json, html and xml documents without Tika. Tika is used as a fallback",
+					},
+					&cli.IntFlag{
+						Name:  "summary-sentences",
+						Usage: "Maximum number of sentences in the document summary shown in search results (0 to disable summaries)",
+						Value: 0,
+					},
+					&cli.IntFlag{
+						Name:  "summary-max-chars",
+						Usage: "Maximum length of the document summary in characters (0 for unlimited)",
+						Value: 500,
+					},
+					&cli.IntFlag{
+						Name:  "summary-min-content-chars",
+						Usage: "Documents with less content are not summarized",
+						Value: 1000,
+					},
+					&cli.IntFlag{
+						Name:  "auto-keywords",
+						Usage: "Maximum number of keywords extracted from the document content (0 to disable automatic keywords)",
+						Value: 0,
+					},
+					&cli.IntFlag{
+						Name:  "auto-keywords-min-occurrences",
+						Usage: "Automatic keywords must occur at least this many times in the document content",
+						Value: 2,
+					},
+					&cli.BoolFlag{
+						Name:  "classify",
+						Usage: "Assign a category (invoice, receipt, bank statement, etc) to documents using the category rules",
 					},
 					&cli.StringFlag{
-						Name:  "elasticsearch-mapping",
-						Usage: "Path to elasticsearch mapping file. Can be used to override static/document-processor/settings.json",
+						Name:  "category-rules",
+						Usage: "Path to a JSON or YAML file with category rules. Can be used to override static/document-processor/category_rules.json",
 						Value: "",
 					},
+					&cli.BoolFlag{
+						Name:  "extract-dates",
+						Usage: "Extract dates from the document content into entities.dates",
+					},
+					&cli.BoolFlag{
+						Name:  "extract-amounts",
+						Usage: "Extract currency amounts from the document content into entities.amounts",
+					},
+					&cli.BoolFlag{
+						Name:  "extract-emails",
+						Usage: "Extract email addresses from the document content into entities.emails",
+					},
+					&cli.BoolFlag{
+						Name:  "extract-phones",
+						Usage: "Extract phone numbers from the document content into entities.phones",
+					},
+					&cli.BoolFlag{
+						Name:  "extract-urls",
+						Usage: "Extract URLs from the document content into entities.urls",
+					},
+					&cli.BoolFlag{
+						Name:  "extract-accounts",
+						Usage: "Extract IBANs and account numbers from the document content into entities.ibans & entities.account_numbers",
+					},
+					&cli.BoolFlag{
+						Name:  "detect-language",
+						Usage: "Detect the content language of documents using Tika, instead of relying on document metadata",
+					},
 
 					&cli.StringFlag{
 						Name:  "ocr-language",
 						Usage: "OCR language override for Tika requests",
 						Value: "",
 					},
+					&cli.StringFlag{
+						Name:  "ocr-rules",
+						Usage: "Path to a JSON or YAML file with OCR rules (strategy, languages, dpi, timeout) per content type or path prefix",
+						Value: "",
+					},
+					&cli.BoolFlag{
+						Name:  "ocr-fallback",
+						Usage: "Resend PDFs to Tika with OCR forced when the extracted text is empty or mostly garbage",
+					},
+					&cli.Float64Flag{
+						Name:  "ocr-fallback-min-quality",
+						Usage: "Minimum text quality score (0-1) of PDFs before falling back to OCR",
+						Value: 0.5,
+					},
+
+					&cli.StringFlag{
+						Name:  "metadata-mapping",
+						Usage: "Path to a JSON or YAML file mapping Tika metadata keys to document fields. Can be used to override static/document-processor/metadata_mapping.json",
+						Value: "",
+					},
+					&cli.StringFlag{
+						Name:  "tag-rules",
+						Usage: "Path to a JSON or YAML file with tag rules (depth limits, stop folders, aliases, path patterns, content types, keywords). Defaults to a tag per folder",
+						Value: "",
+					},
+					&cli.StringSliceFlag{
+						Name:  "meta-raw-include",
+						Usage: "Only store raw Tika metadata keys matching these patterns (eg. 'pdf:*'). Stores all keys when empty",
+					},
+					&cli.StringSliceFlag{
+						Name:  "meta-raw-exclude",
+						Usage: "Never store raw Tika metadata keys matching these patterns (eg. 'access_permission:*')",
+					},
 
 					&cli.StringFlag{
 						Name:  "amqp-url",
@@ -155,7 +450,47 @@ func main() {
 						Name:  "debug",
 						Usage: "Enable debug logging",
 					},
+				}, elasticsearchFlags...),
+			},
+			{
+				Name:  "reindex",
+				Usage: "Migrate documents into a new elasticsearch index, using the current mapping settings",
+				Action: func(c *cli.Context) error {
+
+					processorLogger := logrus.WithFields(logrus.Fields{
+						"type": "document",
+					})
+
+					if c.Bool("debug") {
+						logrus.SetLevel(logrus.DebugLevel)
+					} else {
+						logrus.SetLevel(logrus.InfoLevel)
+					}
+
+					elasticsearchConfig, err := parseElasticsearchConfig(c)
+					if err != nil {
+						return err
+					}
+
+					return document.MigrateIndex(processorLogger, elasticsearchConfig, c.String("metadata-mapping"), c.Bool("force"))
 				},
+
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Reindex documents even if the mapping settings have not changed",
+					},
+					&cli.StringFlag{
+						Name:  "metadata-mapping",
+						Usage: "Path to the metadata mapping file used by the processor, the types of custom metadata fields are part of the index mapping",
+						Value: "",
+					},
+
+					&cli.BoolFlag{
+						Name:  "debug",
+						Usage: "Enable debug logging",
+					},
+				}, elasticsearchFlags...),
 			},
 		},
 	}
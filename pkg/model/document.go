@@ -1,12 +1,34 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// languages with a language specific analyzer in settings.json. The content of documents in these languages is also
+// indexed into a `content_<language>` field, all other documents are only searchable with the standard analyzer.
+var ContentLanguages = []string{"en", "de", "fr", "es", "it", "nl", "pt", "ru"}
 
 type Document struct {
 	ID string `json:"id"`
 
 	Content string `json:"content"`
 
+	// detected language of the content (ISO 639-1 code), see ContentLanguages
+	Language string `json:"language,omitempty"`
+
+	// extractive summary of the content, shown in search results
+	Summary string `json:"summary,omitempty"`
+
+	// per-page content, only available for paginated formats (PDF, presentations)
+	Pages []DocPage `json:"pages,omitempty"`
+
+	// ID of the containing document, only set for embedded documents (archive members, email attachments, etc)
+	ParentID string `json:"parent_id,omitempty"`
+
+	// sequence number (starting at 1) of a content chunk record, only set for chunks of a very large parent document
+	Chunk int `json:"chunk,omitempty"`
+
 	Lodestone DocLodestone `json:"lodestone"`
 
 	// File information/attributes
@@ -17,36 +39,116 @@ type Document struct {
 
 	// Document metadata extracted from document via tika
 	Meta DocMeta `json:"meta"`
+
+	// Structured entities extracted from the content (dates, amounts, etc)
+	Entities DocEntities `json:"entities"`
+}
+
+// MarshalJSON adds the `content_<language>` field, so the content is only analyzed by the analyzer matching its
+// language (rather than by every language analyzer).
+func (d Document) MarshalJSON() ([]byte, error) {
+	type document Document //without the MarshalJSON method
+	data, err := json.Marshal(document(d))
+	if err != nil || d.Content == "" || !isContentLanguage(d.Language) {
+		return data, err
+	}
+
+	content, err := json.Marshal(d.Content)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data[:len(data)-1], `,"content_`+d.Language+`":`...)
+	data = append(data, content...)
+	return append(data, '}'), nil
+}
+
+func isContentLanguage(language string) bool {
+	for _, contentLanguage := range ContentLanguages {
+		if language == contentLanguage {
+			return true
+		}
+	}
+	return false
+}
+
+type DocPage struct {
+	Number  int    `json:"number"` //starts at 1
+	Content string `json:"content"`
 }
 
 type DocLodestone struct {
 	ProcessorVersion string   `json:"processor_version"`
 	Title            string   `json:"title"`
+	TitleSource      string   `json:"title_source,omitempty"`    //meta, content or filename, only set for generated titles
+	GeneratedTitle   string   `json:"generated_title,omitempty"` //title generated during the last update
 	Tags             []string `json:"tags"`
 	Bookmark         bool     `json:"bookmark"`
+
+	// tags derived by the processor during the last update. Tags removed by the user are not added again, unless the
+	// processor did not derive them before.
+	GeneratedTags []string `json:"generated_tags,omitempty"`
+
+	// distinctive terms extracted from the content by the processor, used for tag suggestions and faceting
+	AutoKeywords []string `json:"auto_keywords,omitempty"`
+
+	// document type (eg. "invoice"), assigned by the processor category rules or by the user
+	Category           string  `json:"category,omitempty"`
+	CategorySource     string  `json:"category_source,omitempty"`     //rules, only set for generated categories
+	CategoryConfidence float64 `json:"category_confidence,omitempty"` //0-1, only set for generated categories
+	GeneratedCategory  string  `json:"generated_category,omitempty"`  //category assigned during the last update
 }
 
 type DocFile struct {
-	ContentType  string    `json:"content_type"`
-	FileName     string    `json:"filename"`
-	Extension    string    `json:"extension"` //does not include .
-	Filesize     int64     `json:"filesize"`
-	IndexedChars int64     `json:"indexed_chars"`
-	IndexedDate  time.Time `json:"indexed_date,omitempty"`
-	Created      time.Time `json:"created,omitempty"`
-	LastModified time.Time `json:"last_modified,omitempty"`
-	LastAccessed time.Time `json:"last_accessed,omitempty"`
-	Checksum     string    `json:"checksum"`
+	ContentType  string `json:"content_type"`
+	FileName     string `json:"filename"`
+	Extension    string `json:"extension"` //does not include .
+	Filesize     int64  `json:"filesize"`
+	IndexedChars int64  `json:"indexed_chars"`
+	Truncated    bool   `json:"truncated"` //true if the file or its content exceeded the configured size limits
 
+	ExtractionMethod string    `json:"extraction_method"` //eg. "tika" or "tika_ocr"
+	TextQuality      float64   `json:"text_quality"`      //0 (empty or garbage) to 1 (readable text)
+	IndexedDate      time.Time `json:"indexed_date"`
+	Checksum         string    `json:"checksum"`
+
+	//dates of the original file, nil if unknown (omitempty does not omit zero time.Time values)
+	Created      *time.Time `json:"created,omitempty"` //status change time (ctime)
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	LastAccessed *time.Time `json:"last_accessed,omitempty"`
+
+	//attributes of the original file, as provided by the publisher
 	Group string `json:"group"`
 	Owner string `json:"owner"`
+	Mode  string `json:"mode,omitempty"` //octal permission bits, eg. "0644"
+}
+
+type DocEntities struct {
+	Dates          []time.Time `json:"dates,omitempty"`
+	Amounts        []DocAmount `json:"amounts,omitempty"`
+	Emails         []string    `json:"emails,omitempty"`
+	Phones         []string    `json:"phones,omitempty"` //digits only, with a "+" prefix for international numbers
+	URLs           []string    `json:"urls,omitempty"`
+	Ibans          []string    `json:"ibans,omitempty"` //without spaces
+	AccountNumbers []string    `json:"account_numbers,omitempty"`
+}
+
+type DocAmount struct {
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency"` //ISO 4217 code, eg. "USD"
 }
 
 type DocStorage struct {
-	Bucket      string `json:"bucket"`       //bucket name, does not include "/" char
-	Path        string `json:"path"`         //key, does not include "/" prefix
-	ThumbBucket string `json:"thumb_bucket"` //bucket name, does not include "/" char
-	ThumbPath   string `json:"thumb_path"`   //key, does not include "/" prefix
+	Bucket      string   `json:"bucket"`                //bucket name, does not include "/" char
+	Path        string   `json:"path"`                  //key, does not include "/" prefix
+	Folder      string   `json:"folder"`                //directory of the key, does not include "/" prefix or suffix. Empty for the bucket root
+	FolderTree  []string `json:"folder_tree,omitempty"` //the folder and its ancestors, eg. ["taxes", "taxes/2019"], for folder facets
+	ThumbBucket string   `json:"thumb_bucket"`          //bucket name, does not include "/" char
+	ThumbPath   string   `json:"thumb_path"`            //key, does not include "/" prefix
+
+	// embedded documents are stored with the bucket & path of their parent file
+	VirtualPath   string `json:"virtual_path,omitempty"`   //eg. "archive.zip!/inner/report.pdf"
+	EmbeddedPath  string `json:"embedded_path,omitempty"`  //path within the parent, eg. "/inner/report.pdf"
+	EmbeddedDepth int    `json:"embedded_depth,omitempty"` //1 for resources embedded directly in the parent file
 }
 
 type DocMeta struct {
@@ -74,5 +176,11 @@ type DocMeta struct {
 	Altitude    string   `json:"altitude"`
 	Rating      byte     `json:"rating"`
 	Comments    string   `json:"comments"`
-	Pages       string   `json:"pages"`
+	Pages       int      `json:"pages"`
+
+	// additional fields defined in the `custom` section of the metadata mapping file
+	Custom map[string]interface{} `json:"custom,omitempty"`
+
+	// complete (normalized) metadata map returned by tika, see the meta.raw.* dynamic template
+	Raw map[string]interface{} `json:"raw,omitempty"`
 }
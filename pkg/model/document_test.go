@@ -0,0 +1,40 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocument_MarshalJSON(t *testing.T) {
+	//setup
+	doc := Document{ID: "checksum", Content: "Der \"Bericht\"", Language: "de"}
+
+	//test
+	data, err := json.Marshal(doc)
+
+	//assert
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	require.Equal(t, "Der \"Bericht\"", fields["content"])
+	require.Equal(t, "Der \"Bericht\"", fields["content_de"])
+
+	var unmarshalled Document
+	require.NoError(t, json.Unmarshal(data, &unmarshalled))
+	require.Equal(t, doc.Content, unmarshalled.Content)
+}
+
+func TestDocument_MarshalJSON_OtherLanguage(t *testing.T) {
+	for _, language := range []string{"", "ja"} {
+		data, err := json.Marshal(Document{Content: "content", Language: language})
+		require.NoError(t, err)
+
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &fields))
+		for field := range fields {
+			require.NotContains(t, field, "content_", "language: %q", language)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Original file attributes are passed from the publisher to the processors as S3 user metadata (stored with the
+// object), or in the ResponseElements of the S3Event. Both use the same keys, S3 user metadata keys are case-insensitive.
+const (
+	FileAttributeModified = "x-amz-meta-lodestone-mtime" //RFC3339 timestamp or unix seconds
+	FileAttributeChanged  = "x-amz-meta-lodestone-ctime" //RFC3339 timestamp or unix seconds
+	FileAttributeAccessed = "x-amz-meta-lodestone-atime" //RFC3339 timestamp or unix seconds
+	FileAttributeOwner    = "x-amz-meta-lodestone-owner" //user name (or uid if the user could not be resolved)
+	FileAttributeGroup    = "x-amz-meta-lodestone-group" //group name (or gid if the group could not be resolved)
+	FileAttributeMode     = "x-amz-meta-lodestone-mode"  //octal permission bits, eg. "0644"
+)
+
+// FileAttributes describe the original file, on the filesystem watched by the publisher. Zero values are unknown.
+type FileAttributes struct {
+	Modified time.Time
+	Changed  time.Time
+	Accessed time.Time
+	Owner    string
+	Group    string
+	Mode     os.FileMode
+}
+
+// Metadata encodes the (known) attributes as S3 user metadata/ResponseElements
+func (fa FileAttributes) Metadata() map[string]string {
+	metadata := map[string]string{}
+	for key, value := range map[string]time.Time{
+		FileAttributeModified: fa.Modified,
+		FileAttributeChanged:  fa.Changed,
+		FileAttributeAccessed: fa.Accessed,
+	} {
+		if !value.IsZero() {
+			metadata[key] = value.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if fa.Owner != "" {
+		metadata[FileAttributeOwner] = fa.Owner
+	}
+	if fa.Group != "" {
+		metadata[FileAttributeGroup] = fa.Group
+	}
+	if fa.Mode != 0 {
+		metadata[FileAttributeMode] = fa.ModeString()
+	}
+	return metadata
+}
+
+// ModeString returns the permission bits in octal notation, or "" if the mode is unknown
+func (fa FileAttributes) ModeString() string {
+	if fa.Mode == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04o", fa.Mode.Perm())
+}
+
+// Merge fills the unknown attributes with the values of other
+func (fa FileAttributes) Merge(other FileAttributes) FileAttributes {
+	if fa.Modified.IsZero() {
+		fa.Modified = other.Modified
+	}
+	if fa.Changed.IsZero() {
+		fa.Changed = other.Changed
+	}
+	if fa.Accessed.IsZero() {
+		fa.Accessed = other.Accessed
+	}
+	if fa.Owner == "" {
+		fa.Owner = other.Owner
+	}
+	if fa.Group == "" {
+		fa.Group = other.Group
+	}
+	if fa.Mode == 0 {
+		fa.Mode = other.Mode
+	}
+	return fa
+}
+
+// ParseFileAttributes decodes the attributes stored in S3 user metadata/ResponseElements. Invalid values are ignored,
+// the returned error describes the first invalid value.
+func ParseFileAttributes(metadata map[string]string) (FileAttributes, error) {
+	attributes := FileAttributes{}
+	var firstErr error
+	for key, value := range metadata {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case FileAttributeModified:
+			attributes.Modified, err = parseFileAttributeTime(value)
+		case FileAttributeChanged:
+			attributes.Changed, err = parseFileAttributeTime(value)
+		case FileAttributeAccessed:
+			attributes.Accessed, err = parseFileAttributeTime(value)
+		case FileAttributeOwner:
+			attributes.Owner = value
+		case FileAttributeGroup:
+			attributes.Group = value
+		case FileAttributeMode:
+			var mode uint64
+			mode, err = strconv.ParseUint(value, 8, 32)
+			attributes.Mode = os.FileMode(mode).Perm()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("invalid file attribute %s=%q: %v", key, value, err)
+		}
+	}
+	return attributes, firstErr
+}
+
+// ParseFileAttributesHeader decodes the attributes stored in the S3 user metadata headers of a storage response
+func ParseFileAttributesHeader(header http.Header) (FileAttributes, error) {
+	metadata := map[string]string{}
+	for key := range header {
+		metadata[key] = header.Get(key)
+	}
+	return ParseFileAttributes(metadata)
+}
+
+func parseFileAttributeTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package model
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ReadFileAttributes reads the attributes of a file on the local filesystem
+func ReadFileAttributes(filePath string) (FileAttributes, error) {
+	fileStat, err := os.Stat(filePath)
+	if err != nil {
+		return FileAttributes{}, err
+	}
+
+	attributes := FileAttributes{
+		Modified: fileStat.ModTime().UTC(),
+		Mode:     fileStat.Mode().Perm(),
+	}
+
+	sysStat, ok := fileStat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return attributes, nil
+	}
+	attributes.Changed = time.Unix(int64(sysStat.Ctim.Sec), int64(sysStat.Ctim.Nsec)).UTC()
+	attributes.Accessed = time.Unix(int64(sysStat.Atim.Sec), int64(sysStat.Atim.Nsec)).UTC()
+
+	uid := strconv.FormatUint(uint64(sysStat.Uid), 10)
+	attributes.Owner = uid
+	if usr, err := user.LookupId(uid); err == nil {
+		attributes.Owner = usr.Username
+	}
+
+	gid := strconv.FormatUint(uint64(sysStat.Gid), 10)
+	attributes.Group = gid
+	if grp, err := user.LookupGroupId(gid); err == nil {
+		attributes.Group = grp.Name
+	}
+	return attributes, nil
+}
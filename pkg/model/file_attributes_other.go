@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package model
+
+import (
+	"os"
+)
+
+// ReadFileAttributes reads the attributes of a file on the local filesystem. Only the modification time & mode are
+// available on this platform.
+func ReadFileAttributes(filePath string) (FileAttributes, error) {
+	fileStat, err := os.Stat(filePath)
+	if err != nil {
+		return FileAttributes{}, err
+	}
+
+	return FileAttributes{
+		Modified: fileStat.ModTime().UTC(),
+		Mode:     fileStat.Mode().Perm(),
+	}, nil
+}
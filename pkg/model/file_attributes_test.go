@@ -0,0 +1,88 @@
+package model
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAttributes_Metadata(t *testing.T) {
+	attributes := FileAttributes{
+		Modified: time.Date(2019, time.April, 1, 9, 30, 0, 0, time.UTC),
+		Changed:  time.Date(2019, time.April, 2, 10, 0, 0, 500, time.UTC),
+		Owner:    "jason",
+		Group:    "staff",
+		Mode:     0640,
+	}
+
+	metadata := attributes.Metadata()
+	require.Equal(t, map[string]string{
+		"x-amz-meta-lodestone-mtime": "2019-04-01T09:30:00Z",
+		"x-amz-meta-lodestone-ctime": "2019-04-02T10:00:00.0000005Z",
+		"x-amz-meta-lodestone-owner": "jason",
+		"x-amz-meta-lodestone-group": "staff",
+		"x-amz-meta-lodestone-mode":  "0640",
+	}, metadata)
+
+	parsed, err := ParseFileAttributes(metadata)
+	require.NoError(t, err)
+	require.Equal(t, attributes, parsed)
+}
+
+func TestParseFileAttributes(t *testing.T) {
+	attributes, err := ParseFileAttributes(map[string]string{
+		"X-Amz-Meta-Lodestone-Mtime": "1554111000",
+		"x-amz-meta-lodestone-ctime": "yesterday",
+		"x-amz-meta-lodestone-mode":  "0755",
+		"x-amz-meta-other":           "ignored",
+	})
+
+	require.EqualError(t, err, `invalid file attribute x-amz-meta-lodestone-ctime="yesterday": parsing time "yesterday" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "yesterday" as "2006"`)
+	require.Equal(t, FileAttributes{
+		Modified: time.Date(2019, time.April, 1, 9, 30, 0, 0, time.UTC),
+		Mode:     0755,
+	}, attributes)
+}
+
+func TestParseFileAttributesHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Amz-Meta-Lodestone-Owner", "jason")
+	header.Set("X-Amz-Meta-Lodestone-Group", "1000")
+	header.Set("Content-Type", "application/pdf")
+
+	attributes, err := ParseFileAttributesHeader(header)
+	require.NoError(t, err)
+	require.Equal(t, FileAttributes{Owner: "jason", Group: "1000"}, attributes)
+}
+
+func TestFileAttributes_Merge(t *testing.T) {
+	stored := FileAttributes{Owner: "jason", Mode: 0600}
+	event := FileAttributes{Owner: "root", Group: "staff", Mode: 0644}
+
+	require.Equal(t, FileAttributes{Owner: "jason", Group: "staff", Mode: 0600}, stored.Merge(event))
+}
+
+func TestReadFileAttributes(t *testing.T) {
+	file, err := ioutil.TempFile("", "attributes")
+	require.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+	require.NoError(t, os.Chmod(file.Name(), 0640))
+	modified := time.Date(2019, time.April, 1, 9, 30, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(file.Name(), modified, modified))
+
+	attributes, err := ReadFileAttributes(file.Name())
+	require.NoError(t, err)
+	require.Equal(t, modified, attributes.Modified)
+	require.Equal(t, os.FileMode(0640), attributes.Mode)
+
+	if currentUser, err := user.Current(); err == nil && attributes.Owner != "" {
+		//the owner is the user name, not the name of a group with the same id
+		require.Equal(t, currentUser.Username, attributes.Owner)
+	}
+}
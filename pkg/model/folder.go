@@ -0,0 +1,12 @@
+package model
+
+// Folder is stored in the folder index (see static/document-processor/settings_folder.json), so that the folder tree
+// can be browsed without aggregating over all documents. A folder exists while it (or one of its subfolders) contains
+// at least one document.
+type Folder struct {
+	Root    string `json:"root"`    //bucket name, does not include "/" char
+	Real    string `json:"real"`    //folder path within the bucket, eg. "taxes/2019". Does not include "/" prefix or suffix
+	Virtual string `json:"virtual"` //bucket name and folder path, eg. "documents/taxes/2019". Unique across buckets
+	Parent  string `json:"parent"`  //path of the parent folder within the bucket, "" for top level folders
+	Name    string `json:"name"`    //eg. "2019"
+}
@@ -74,6 +74,7 @@ func (e *S3Event) Create(publisherName string, eventName string, sourceBucket st
 
 	fileSize := int64(0)
 	fileMD5 := ""
+	responseElements := make(map[string]string)
 	if eventName == "s3:ObjectCreated:Put" {
 		fileMetadata, err := os.Stat(sourceRawPath)
 		if err != nil {
@@ -81,6 +82,13 @@ func (e *S3Event) Create(publisherName string, eventName string, sourceBucket st
 		}
 		fileSize = fileMetadata.Size()
 		fileMD5, err = fileMD5Hash(sourceRawPath)
+
+		//pass the original file attributes to the processors, the processors only see a copy of the file
+		fileAttributes, err := ReadFileAttributes(sourceRawPath)
+		if err != nil {
+			return err
+		}
+		responseElements = fileAttributes.Metadata()
 	}
 
 	record := S3EventRecord{
@@ -95,7 +103,7 @@ func (e *S3Event) Create(publisherName string, eventName string, sourceBucket st
 		RequestParameters: S3RequestParameters{
 			SourceIPAddress: localIP(),
 		},
-		ResponseElements: make(map[string]string),
+		ResponseElements: responseElements,
 		S3: S3Entity{
 			SchemaVersion:   "1.0",
 			ConfigurationID: "Config",
@@ -115,6 +115,22 @@ func ReadFile(apiEndpoint *url.URL, storageBucket string, storagePath string, ou
 	return localFilepath, err
 }
 
+// OpenFile streams a file from the storage api. The response header includes the S3 user metadata of the file
+// (X-Amz-Meta-*). The caller is responsible for closing the returned body.
+func OpenFile(apiEndpoint *url.URL, storageBucket string, storagePath string) (io.ReadCloser, http.Header, error) {
+	//manipulate the path
+	apiEndpoint.Path = fmt.Sprintf("/api/v1/storage/%s/%s", storageBucket, storagePath)
+	resp, err := http.Get(apiEndpoint.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("could not retrieve %s/%s from storage: %s", storageBucket, storagePath, resp.Status)
+	}
+	return resp.Body, resp.Header, nil
+}
+
 func DeleteFile(apiEndpoint *url.URL, storageBucket string, storagePath string) error {
 
 	//manipulate the path
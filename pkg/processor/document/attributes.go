@@ -0,0 +1,40 @@
+package document
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// event sources of the Lodestone publishers, which include the file attributes in the event ResponseElements. S3 does
+// not include user metadata in events.
+const lodestonePublisherEventSource = "lodestone:publisher:"
+
+// fileAttributes determines the attributes of the original file. Attributes stored as S3 user metadata (returned as
+// headers when the object is downloaded) take precedence, they are stored with the object so they are also available when a
+// document is reprocessed. They are followed by the attributes a Lodestone publisher included in the event. The
+// processor only has access to a temporary copy of the file, so when the modification time is unknown the storage
+// modification time is used instead.
+func (dp *DocumentProcessor) fileAttributes(record model.S3EventRecord, storageHeader http.Header) model.FileAttributes {
+	storedAttributes, err := model.ParseFileAttributesHeader(storageHeader)
+	if err != nil {
+		dp.logger.Warnf("Ignoring file attribute stored in S3 user metadata: %v", err)
+	}
+
+	var eventAttributes model.FileAttributes
+	if strings.HasPrefix(record.EventSource, lodestonePublisherEventSource) {
+		eventAttributes, err = model.ParseFileAttributes(record.ResponseElements)
+		if err != nil {
+			dp.logger.Warnf("Ignoring file attribute included in event: %v", err)
+		}
+	}
+
+	attributes := storedAttributes.Merge(eventAttributes)
+	if attributes.Modified.IsZero() {
+		if lastModified, err := http.ParseTime(storageHeader.Get("Last-Modified")); err == nil {
+			attributes.Modified = lastModified.UTC()
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,64 @@
+package document
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentProcessor_FileAttributes(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{logger: logrus.WithField("test", t.Name())}
+	header := http.Header{}
+	header.Set("X-Amz-Meta-Lodestone-Owner", "jason")
+	header.Set("X-Amz-Meta-Lodestone-Mtime", "2019-04-01T09:30:00Z")
+	header.Set("Last-Modified", "Mon, 02 Dec 2019 10:00:00 GMT")
+	record := model.S3EventRecord{EventSource: "lodestone:publisher:fs", ResponseElements: map[string]string{
+		"x-amz-meta-lodestone-owner": "root",
+		"x-amz-meta-lodestone-group": "staff",
+		"x-amz-meta-lodestone-mode":  "not-octal",
+	}}
+
+	//test
+	attributes := proc.fileAttributes(record, header)
+
+	//assert
+	require.Equal(t, model.FileAttributes{
+		Modified: time.Date(2019, time.April, 1, 9, 30, 0, 0, time.UTC),
+		Owner:    "jason",
+		Group:    "staff",
+	}, attributes)
+}
+
+func TestDocumentProcessor_FileAttributes_StorageModifiedTime(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{logger: logrus.WithField("test", t.Name())}
+	header := http.Header{}
+	header.Set("Last-Modified", "Mon, 02 Dec 2019 10:00:00 GMT")
+
+	//test
+	attributes := proc.fileAttributes(model.S3EventRecord{}, header)
+
+	//assert
+	require.Equal(t, model.FileAttributes{Modified: time.Date(2019, time.December, 2, 10, 0, 0, 0, time.UTC)}, attributes)
+}
+
+func TestDocumentProcessor_FileAttributes_S3Event(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{logger: logrus.WithField("test", t.Name())}
+	header := http.Header{}
+	header.Set("X-Amz-Meta-Lodestone-Owner", "jason")
+	record := model.S3EventRecord{EventSource: "minio:s3", ResponseElements: map[string]string{
+		"x-amz-meta-lodestone-group": "staff",
+	}}
+
+	//test
+	attributes := proc.fileAttributes(record, header)
+
+	//assert
+	require.Equal(t, model.FileAttributes{Owner: "jason"}, attributes, "only lodestone publishers include attributes in events")
+}
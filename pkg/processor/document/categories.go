@@ -0,0 +1,221 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/markbates/pkger"
+	"gopkg.in/yaml.v2"
+)
+
+// ClassificationConfig configures the assignment of lodestone.category
+type ClassificationConfig struct {
+	Enabled bool
+
+	// path to a JSON or YAML file containing category rules, see categoryRules. Defaults to
+	// static/document-processor/category_rules.json
+	RulesPath string
+}
+
+// source of generated categories, stored in lodestone.category_source
+const categorySourceRules = "rules"
+
+// number of content characters used to classify a document, classification keywords are usually found on the first
+// pages
+const classificationSampleChars = 20000
+
+// fields a category condition can match
+const (
+	categoryFieldContent = "content"
+	categoryFieldTitle   = "title"  //meta.title
+	categoryFieldAuthor  = "author" //meta.author
+	categoryFieldPath    = "path"   //storage path
+)
+
+// categoryRules assign a lodestone.category to documents. Every rule is scored: the confidence of a rule is the weight
+// of its matching conditions divided by the weight of all its conditions. Of the rules with a confidence of at least
+// MinConfidence, the rule with the highest priority (then highest confidence) determines the category.
+type categoryRules struct {
+	MinConfidence float64        `json:"min_confidence" yaml:"min_confidence"` //defaults to 0.5
+	Rules         []categoryRule `json:"rules" yaml:"rules"`
+}
+
+type categoryRule struct {
+	Category      string              `json:"category" yaml:"category"`
+	Priority      int                 `json:"priority" yaml:"priority"`
+	MinConfidence float64             `json:"min_confidence" yaml:"min_confidence"` //overrides the global min confidence
+	Conditions    []categoryCondition `json:"conditions" yaml:"conditions"`
+}
+
+// categoryCondition matches when any of its keywords (case-insensitive, whole words) or its regular expression is
+// found in the field.
+type categoryCondition struct {
+	Field    string   `json:"field" yaml:"field"`
+	Keywords []string `json:"keywords" yaml:"keywords"`
+	Pattern  string   `json:"pattern" yaml:"pattern"`
+	Weight   float64  `json:"weight" yaml:"weight"` //defaults to 1
+
+	patterns []*regexp.Regexp
+}
+
+// loadCategoryRules loads the category rules file, or the embedded default rules when rulesPath is empty.
+func loadCategoryRules(rulesPath string) (*categoryRules, error) {
+	var rulesFile io.ReadCloser
+	var err error
+
+	if rulesPath == "" {
+		rulesFile, err = pkger.Open("/static/document-processor/category_rules.json")
+	} else {
+		rulesFile, err = os.Open(rulesPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open category rules file: %v", err)
+	}
+	defer rulesFile.Close()
+
+	rulesData, err := ioutil.ReadAll(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules categoryRules
+	switch strings.ToLower(filepath.Ext(rulesPath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(rulesData, &rules)
+	default:
+		err = json.Unmarshal(rulesData, &rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse category rules file: %v", err)
+	}
+
+	return &rules, rules.validate()
+}
+
+// validate checks the rules, and compiles the condition patterns
+func (cr *categoryRules) validate() error {
+	if cr.MinConfidence == 0 {
+		cr.MinConfidence = 0.5
+	}
+	if cr.MinConfidence < 0 || cr.MinConfidence > 1 {
+		return fmt.Errorf("category rules: min confidence must be between 0 and 1")
+	}
+
+	for i := range cr.Rules {
+		rule := &cr.Rules[i]
+		if rule.Category == "" {
+			return fmt.Errorf("category rule %d: a category is required", i)
+		}
+		if rule.MinConfidence < 0 || rule.MinConfidence > 1 {
+			return fmt.Errorf("category rule %d (%s): min confidence must be between 0 and 1", i, rule.Category)
+		}
+		if len(rule.Conditions) == 0 {
+			return fmt.Errorf("category rule %d (%s): at least one condition is required", i, rule.Category)
+		}
+
+		for j := range rule.Conditions {
+			condition := &rule.Conditions[j]
+			switch condition.Field {
+			case categoryFieldContent, categoryFieldTitle, categoryFieldAuthor, categoryFieldPath:
+			default:
+				return fmt.Errorf("category rule %d (%s): unknown field %q", i, rule.Category, condition.Field)
+			}
+			if condition.Weight < 0 {
+				return fmt.Errorf("category rule %d (%s): weight cannot be negative", i, rule.Category)
+			} else if condition.Weight == 0 {
+				condition.Weight = 1
+			}
+
+			condition.patterns = nil
+			if len(condition.Keywords) > 0 {
+				keywords := []string{}
+				for _, keyword := range condition.Keywords {
+					keywords = append(keywords, regexp.QuoteMeta(strings.TrimSpace(keyword)))
+				}
+				condition.patterns = append(condition.patterns, regexp.MustCompile(`(?i)\b(?:`+strings.Join(keywords, "|")+`)\b`))
+			}
+			if condition.Pattern != "" {
+				pattern, err := regexp.Compile(condition.Pattern)
+				if err != nil {
+					return fmt.Errorf("category rule %d (%s): invalid pattern %q: %v", i, rule.Category, condition.Pattern, err)
+				}
+				condition.patterns = append(condition.patterns, pattern)
+			}
+			if len(condition.patterns) == 0 {
+				return fmt.Errorf("category rule %d (%s): conditions require keywords or a pattern", i, rule.Category)
+			}
+		}
+	}
+	return nil
+}
+
+// classifyDocument sets the category of the document (see classify), and its source when a rule matched.
+func (dp *DocumentProcessor) classifyDocument(doc *model.Document) {
+	doc.Lodestone.Category, doc.Lodestone.CategoryConfidence = dp.categoryRules.classify(*doc)
+	if doc.Lodestone.Category != "" {
+		doc.Lodestone.CategorySource = categorySourceRules
+	}
+}
+
+// classify returns the category of the document and its confidence (0-1), or "" if no rule matched.
+func (cr *categoryRules) classify(doc model.Document) (string, float64) {
+	if cr == nil {
+		return "", 0
+	}
+
+	content, _ := truncateText(doc.Content, classificationSampleChars)
+	fields := map[string]string{
+		categoryFieldContent: content,
+		categoryFieldTitle:   doc.Meta.Title,
+		categoryFieldAuthor:  doc.Meta.Author,
+		categoryFieldPath:    doc.Storage.Path,
+	}
+
+	var selected *categoryRule
+	selectedConfidence := 0.0
+	for i := range cr.Rules {
+		rule := &cr.Rules[i]
+		confidence := rule.confidence(fields)
+
+		minConfidence := cr.MinConfidence
+		if rule.MinConfidence > 0 {
+			minConfidence = rule.MinConfidence
+		}
+		if confidence == 0 || confidence < minConfidence {
+			continue
+		}
+		if selected == nil || rule.Priority > selected.Priority || (rule.Priority == selected.Priority && confidence > selectedConfidence) {
+			selected, selectedConfidence = rule, confidence
+		}
+	}
+
+	if selected == nil {
+		return "", 0
+	}
+	return selected.Category, selectedConfidence
+}
+
+func (rule categoryRule) confidence(fields map[string]string) float64 {
+	matched, total := 0.0, 0.0
+	for _, condition := range rule.Conditions {
+		total += condition.Weight
+		for _, pattern := range condition.patterns {
+			if pattern.MatchString(fields[condition.Field]) {
+				matched += condition.Weight
+				break
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	//rounded, so the stored confidence is stable (eg. 0.67 instead of 0.6666666666666666)
+	return float64(int(matched/total*100+0.5)) / 100
+}
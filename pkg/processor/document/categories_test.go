@@ -0,0 +1,128 @@
+package document
+
+import (
+	"os"
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCategoryRules_Default(t *testing.T) {
+	//setup
+	//the embedded default rules (pkger) are only available in packaged builds, read them from the static folder instead
+	rules, err := loadCategoryRules("../../../static/document-processor/category_rules.json")
+	require.NoError(t, err)
+
+	var tests = []struct {
+		doc           model.Document
+		category      string
+		minConfidence float64
+	}{
+		{model.Document{Content: "ACME Corp\nInvoice Number: 2019-042\nAmount due: $1,234.56"}, "invoice", 0.75},
+		{model.Document{Content: "Thank you for your purchase!\nSubtotal 9.99\nVISA", Storage: model.DocStorage{Path: "receipts/store.pdf"}}, "receipt", 1},
+		{model.Document{Content: "Statement period: April 2019\nOpening balance 100.00\nClosing balance 50.00"}, "bank_statement", 0.5},
+		{model.Document{Content: "Dear John, see you on Friday."}, "", 0},
+	}
+
+	for _, test := range tests {
+		//test
+		category, confidence := rules.classify(test.doc)
+
+		//assert
+		require.Equal(t, test.category, category, test.doc.Content)
+		require.True(t, confidence >= test.minConfidence, "confidence %v of %q", confidence, test.doc.Content)
+	}
+}
+
+func TestLoadCategoryRules(t *testing.T) {
+	//setup
+	rulesPath := writeMetadataMapping(t, "categories*.yaml", `
+min_confidence: 0.6
+rules:
+  - category: contract
+    priority: 10
+    conditions:
+      - field: content
+        keywords: ["agreement"]
+      - field: title
+        pattern: '(?i)contract'
+  - category: letter
+    conditions:
+      - field: content
+        keywords: ["dear", "sincerely"]
+        weight: 3
+      - field: author
+        keywords: ["john doe"]
+  - category: note
+    min_confidence: 0.1
+    conditions:
+      - field: path
+        pattern: '^notes/'
+      - field: content
+        keywords: ["todo"]
+        weight: 9
+`)
+	defer os.Remove(rulesPath)
+
+	//test
+	rules, err := loadCategoryRules(rulesPath)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 0.6, rules.MinConfidence)
+	require.Len(t, rules.Rules, 3)
+
+	//the contract rule has a higher priority than the (more confident) letter rule
+	category, confidence := rules.classify(model.Document{
+		Content: "Dear Jane, please sign the agreement. Sincerely",
+		Meta:    model.DocMeta{Title: "Contract", Author: "John Doe"},
+	})
+	require.Equal(t, "contract", category)
+	require.Equal(t, 1.0, confidence)
+
+	//the contract rule is below the min confidence (0.5)
+	category, confidence = rules.classify(model.Document{Content: "Dear Jane, please sign the agreement."})
+	require.Equal(t, "letter", category)
+	require.Equal(t, 0.75, confidence)
+
+	//rule min confidence overrides the global min confidence
+	category, confidence = rules.classify(model.Document{Storage: model.DocStorage{Path: "notes/shopping.txt"}})
+	require.Equal(t, "note", category)
+	require.Equal(t, 0.1, confidence)
+
+	//keywords match whole words only
+	category, _ = rules.classify(model.Document{Content: "Dearest Jane"})
+	require.Equal(t, "", category)
+
+	//processors created without rules (classification disabled) do not assign categories
+	var noRules *categoryRules
+	category, confidence = noRules.classify(model.Document{Content: "Invoice"})
+	require.Equal(t, "", category)
+	require.Equal(t, 0.0, confidence)
+}
+
+func TestLoadCategoryRules_Invalid(t *testing.T) {
+	var tests = []string{
+		`{"min_confidence": 2}`,
+		`{"rules": [{"conditions": [{"field": "content", "keywords": ["invoice"]}]}]}`,
+		`{"rules": [{"category": "invoice"}]}`,
+		`{"rules": [{"category": "invoice", "conditions": [{"field": "body", "keywords": ["invoice"]}]}]}`,
+		`{"rules": [{"category": "invoice", "conditions": [{"field": "content"}]}]}`,
+		`{"rules": [{"category": "invoice", "conditions": [{"field": "content", "pattern": "(invoice"}]}]}`,
+		`{"rules": [{"category": "invoice", "conditions": [{"field": "content", "keywords": ["invoice"], "weight": -1}]}]}`,
+		`not json`,
+	}
+
+	for _, test := range tests {
+		//setup
+		rulesPath := writeMetadataMapping(t, "categories*.json", test)
+		defer os.Remove(rulesPath)
+
+		//test
+		_, err := loadCategoryRules(rulesPath)
+
+		//assert
+		require.Error(t, err, test)
+	}
+}
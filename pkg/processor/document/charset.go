@@ -0,0 +1,130 @@
+package document
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// character sets detected by decodeText
+const (
+	charsetUTF8        = "UTF-8"
+	charsetUTF16LE     = "UTF-16LE"
+	charsetUTF16BE     = "UTF-16BE"
+	charsetISO88591    = "ISO-8859-1"
+	charsetWindows1252 = "windows-1252"
+)
+
+// charset declared in the first bytes of HTML (<meta charset="...">) and XML (<?xml encoding="..."?>) documents
+var declaredCharsetPattern = regexp.MustCompile(`(?i)(?:<meta[^>]+charset\s*=\s*["']?|<\?xml[^>]+encoding\s*=\s*["'])([a-z0-9_\-]+)`)
+
+// windows-1252 differs from ISO-8859-1 in the 0x80-0x9F range, undefined characters are mapped to their C1 control.
+var windows1252Table = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// decodeText converts text in an unknown character set to UTF-8, returning the text and the detected character set.
+// Byte order marks take precedence, followed by valid UTF-8, the character set declared in the document, and UTF-16
+// detection. Anything else is decoded as windows-1252, which is a superset of the printable ISO-8859-1 characters.
+// Truncated data (see ContentLimits.TikaMaxBytes) can end in the middle of a UTF-8 character, which is removed.
+func decodeText(data []byte, truncated bool) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:]), charsetUTF8
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false), charsetUTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true), charsetUTF16BE
+	}
+
+	text := data
+	if truncated {
+		text = trimIncompleteRune(data)
+	}
+	if utf8.Valid(text) && !looksLikeUTF16(text) {
+		return string(text), charsetUTF8
+	}
+
+	declared := ""
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if matches := declaredCharsetPattern.FindSubmatch(head); matches != nil {
+		declared = strings.ToLower(string(matches[1]))
+	}
+
+	switch {
+	case declared == "utf-16be" || (declared == "" && looksLikeUTF16(data) && data[0] == 0):
+		return decodeUTF16(data, true), charsetUTF16BE
+	case strings.HasPrefix(declared, "utf-16") || (declared == "" && looksLikeUTF16(data)):
+		return decodeUTF16(data, false), charsetUTF16LE
+	case declared == "iso-8859-1" || declared == "latin1" || declared == "latin-1":
+		return decodeISO88591(data), charsetISO88591
+	default:
+		return decodeWindows1252(data), charsetWindows1252
+	}
+}
+
+// trimIncompleteRune removes an incomplete UTF-8 character (up to 3 bytes) from the end of data
+func trimIncompleteRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if start := len(data) - i; utf8.RuneStart(data[start]) {
+			if data[start] >= utf8.RuneSelf && !utf8.FullRune(data[start:]) {
+				return data[:start]
+			}
+			return data
+		}
+	}
+	return data
+}
+
+// looksLikeUTF16 detects UTF-16 text without a byte order mark, where (mostly ASCII) text has a zero byte in every
+// other position.
+func looksLikeUTF16(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	zeros := 0
+	for _, b := range data {
+		if b == 0 {
+			zeros++
+		}
+	}
+	return zeros*3 >= len(data)
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeISO88591(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func decodeWindows1252(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			runes[i] = windows1252Table[b-0x80]
+		} else {
+			runes[i] = rune(b)
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,153 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+)
+
+const (
+	clusterFlavorElasticsearch = "elasticsearch"
+	clusterFlavorOpenSearch    = "opensearch"
+)
+
+// clusterInfo describes the search cluster we are connected to. The processor uses the v7 elasticsearch client, and
+// the typeless (v7) mapping format, which is compatible with Elasticsearch 7.x & 8.x and OpenSearch 1.x & 2.x, as long
+// as we account for the few differences below.
+type clusterInfo struct {
+	Flavor       string
+	Version      string
+	MajorVersion int
+}
+
+// Elasticsearch 8 only accepts (and returns) v7 formatted requests when the client asks for them explicitly.
+func (ci clusterInfo) requiresCompatibilityHeaders() bool {
+	return ci.Flavor == clusterFlavorElasticsearch && ci.MajorVersion >= 8
+}
+
+// Elasticsearch 8 removed the deprecated camelCase date format names. OpenSearch still accepts them, but they have
+// been deprecated since Elasticsearch 7, so we always convert them.
+var camelCaseDateFormats = map[string]string{
+	"dateOptionalTime":             "date_optional_time",
+	"strictDateOptionalTime":       "strict_date_optional_time",
+	"strictDateOptionalTimeNanos":  "strict_date_optional_time_nanos",
+	"basicDate":                    "basic_date",
+	"basicDateTime":                "basic_date_time",
+	"basicDateTimeNoMillis":        "basic_date_time_no_millis",
+	"dateHour":                     "date_hour",
+	"dateHourMinute":               "date_hour_minute",
+	"dateHourMinuteSecond":         "date_hour_minute_second",
+	"dateHourMinuteSecondFraction": "date_hour_minute_second_fraction",
+	"dateHourMinuteSecondMillis":   "date_hour_minute_second_millis",
+	"dateTime":                     "date_time",
+	"dateTimeNoMillis":             "date_time_no_millis",
+	"epochMillis":                  "epoch_millis",
+	"epochSecond":                  "epoch_second",
+	"strictDate":                   "strict_date",
+	"strictDateTime":               "strict_date_time",
+	"strictDateTimeNoMillis":       "strict_date_time_no_millis",
+}
+
+// detectCluster retrieves the cluster flavor and version from the root endpoint.
+func detectCluster(es *elasticsearch.Client) (clusterInfo, error) {
+	resp, err := es.Info()
+	if err != nil {
+		return clusterInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return clusterInfo{}, fmt.Errorf("could not retrieve cluster information: %s", resp.String())
+	}
+
+	var info struct {
+		Tagline string `json:"tagline"`
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return clusterInfo{}, fmt.Errorf("could not parse cluster information: %v", err)
+	}
+
+	cluster := clusterInfo{
+		Flavor:  clusterFlavorElasticsearch,
+		Version: info.Version.Number,
+	}
+	if info.Version.Distribution == clusterFlavorOpenSearch || strings.Contains(strings.ToLower(info.Tagline), "opensearch") {
+		cluster.Flavor = clusterFlavorOpenSearch
+	}
+
+	cluster.MajorVersion, err = strconv.Atoi(strings.SplitN(cluster.Version, ".", 2)[0])
+	if err != nil {
+		return clusterInfo{}, fmt.Errorf("could not parse cluster version %q", cluster.Version)
+	}
+
+	//opensearch reports version 7.10.2 when compatibility.override_main_response_version is enabled
+	if cluster.Flavor == clusterFlavorElasticsearch && (cluster.MajorVersion < 7 || cluster.MajorVersion > 8) {
+		return clusterInfo{}, fmt.Errorf("unsupported elasticsearch version %s, only 7.x and 8.x are supported", cluster.Version)
+	} else if cluster.Flavor == clusterFlavorOpenSearch && (cluster.MajorVersion < 1 || cluster.MajorVersion > 2) && cluster.MajorVersion != 7 {
+		return clusterInfo{}, fmt.Errorf("unsupported opensearch version %s, only 1.x and 2.x are supported", cluster.Version)
+	}
+	return cluster, nil
+}
+
+// compatibleIndexSettings converts index settings (from settings.json or an override file) so they are accepted by
+// every supported cluster. The settings are written for Elasticsearch 7, and are used unchanged on Elasticsearch 8 and
+// OpenSearch, except for the deprecated camelCase date formats, which are converted regardless of the cluster.
+func compatibleIndexSettings(indexSettings map[string]interface{}) map[string]interface{} {
+	if mappings, ok := indexSettings["mappings"].(map[string]interface{}); ok {
+		convertDateFormats(mappings)
+	}
+	return indexSettings
+}
+
+func convertDateFormats(mapping interface{}) {
+	switch typedMapping := mapping.(type) {
+	case map[string]interface{}:
+		for key, val := range typedMapping {
+			if formatStr, ok := val.(string); ok && key == "format" {
+				formats := strings.Split(formatStr, "||")
+				for i, format := range formats {
+					if converted, found := camelCaseDateFormats[format]; found {
+						formats[i] = converted
+					}
+				}
+				typedMapping[key] = strings.Join(formats, "||")
+			} else {
+				convertDateFormats(val)
+			}
+		}
+	case []interface{}:
+		for _, item := range typedMapping {
+			convertDateFormats(item)
+		}
+	}
+}
+
+// compatibilityTransport adds the Elasticsearch 8 REST API compatibility headers to every request.
+// https://www.elastic.co/guide/en/elasticsearch/reference/8.0/rest-api-compatibility.html
+type compatibilityTransport struct {
+	transport estransport.Interface
+}
+
+const compatibilityMediaType = "application/vnd.elasticsearch+json;compatible-with=7"
+
+func (t *compatibilityTransport) Perform(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", compatibilityMediaType)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Header.Set("Content-Type", compatibilityMediaType)
+	}
+	return t.transport.Perform(req)
+}
+
+func withCompatibilityHeaders(es *elasticsearch.Client) *elasticsearch.Client {
+	compatTransport := &compatibilityTransport{transport: es.Transport}
+	return &elasticsearch.Client{Transport: compatTransport, API: esapi.New(compatTransport)}
+}
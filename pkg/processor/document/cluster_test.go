@@ -0,0 +1,159 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// root endpoint responses returned by each supported (and unsupported) cluster flavor
+var clusterInfoResponses = map[string]string{
+	"elasticsearch-6": `{"name":"es","cluster_name":"docker-cluster","version":{"number":"6.8.23","build_flavor":"default"},"tagline":"You Know, for Search"}`,
+	"elasticsearch-7": `{"name":"es","cluster_name":"docker-cluster","version":{"number":"7.10.2","build_flavor":"default","lucene_version":"8.7.0"},"tagline":"You Know, for Search"}`,
+	"elasticsearch-8": `{"name":"es","cluster_name":"docker-cluster","version":{"number":"8.11.1","build_flavor":"default","lucene_version":"9.8.0"},"tagline":"You Know, for Search"}`,
+	"opensearch-1":    `{"name":"os","cluster_name":"docker-cluster","version":{"distribution":"opensearch","number":"1.3.14","lucene_version":"8.10.1"},"tagline":"The OpenSearch Project: https://opensearch.org/"}`,
+	"opensearch-2":    `{"name":"os","cluster_name":"docker-cluster","version":{"distribution":"opensearch","number":"2.11.0","lucene_version":"9.7.0"},"tagline":"The OpenSearch Project: https://opensearch.org/"}`,
+	//opensearch with compatibility.override_main_response_version enabled
+	"opensearch-compat": `{"name":"os","cluster_name":"docker-cluster","version":{"number":"7.10.2","lucene_version":"9.7.0"},"tagline":"The OpenSearch Project: https://opensearch.org/"}`,
+}
+
+type clusterStubRequest struct {
+	Method      string
+	Path        string
+	Accept      string
+	ContentType string
+	Body        map[string]interface{}
+}
+
+func newClusterStub(t *testing.T, flavor string, requests *[]clusterStubRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := clusterStubRequest{
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Accept:      r.Header.Get("Accept"),
+			ContentType: r.Header.Get("Content-Type"),
+		}
+		if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+			require.NoError(t, json.Unmarshal(body, &request.Body))
+		}
+		*requests = append(*requests, request)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, clusterInfoResponses[flavor])
+		case r.URL.Path == "/_alias/lodestone" || r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{}`)
+		case strings.HasSuffix(r.URL.Path, "/_delete_by_query"):
+			fmt.Fprint(w, `{"deleted": 1, "failures": []}`)
+		default:
+			fmt.Fprint(w, `{"acknowledged": true}`)
+		}
+	}))
+}
+
+func TestDetectCluster(t *testing.T) {
+	var tests = []struct {
+		flavor   string
+		expected clusterInfo
+		err      string
+	}{
+		{"elasticsearch-6", clusterInfo{}, "unsupported elasticsearch version 6.8.23"},
+		{"elasticsearch-7", clusterInfo{Flavor: "elasticsearch", Version: "7.10.2", MajorVersion: 7}, ""},
+		{"elasticsearch-8", clusterInfo{Flavor: "elasticsearch", Version: "8.11.1", MajorVersion: 8}, ""},
+		{"opensearch-1", clusterInfo{Flavor: "opensearch", Version: "1.3.14", MajorVersion: 1}, ""},
+		{"opensearch-2", clusterInfo{Flavor: "opensearch", Version: "2.11.0", MajorVersion: 2}, ""},
+		{"opensearch-compat", clusterInfo{Flavor: "opensearch", Version: "7.10.2", MajorVersion: 7}, ""},
+	}
+
+	for _, tt := range tests {
+		requests := []clusterStubRequest{}
+		server := newClusterStub(t, tt.flavor, &requests)
+		es, err := newElasticsearchClient(ElasticsearchConfig{Addresses: []string{server.URL}, Index: "lodestone"})
+		require.NoError(t, err)
+
+		cluster, err := detectCluster(es)
+		server.Close()
+
+		if tt.err == "" {
+			require.NoError(t, err, tt.flavor)
+			require.Equal(t, tt.expected, cluster, tt.flavor)
+		} else {
+			require.Error(t, err, tt.flavor)
+			require.Contains(t, err.Error(), tt.err, tt.flavor)
+		}
+	}
+}
+
+func TestDocumentProcessor_ClusterCompatibility(t *testing.T) {
+
+	//override file using the deprecated camelCase date formats
+	settingsFile, err := ioutil.TempFile("", "settings*.json")
+	require.NoError(t, err)
+	settingsFile.WriteString(`{"mappings": {"properties": {
+		"created": {"type": "date", "format": "dateOptionalTime"},
+		"modified": {"type": "date", "format": "strictDateOptionalTime||epochMillis"}
+	}}}`)
+	settingsFile.Close()
+	defer os.Remove(settingsFile.Name())
+
+	for _, flavor := range []string{"elasticsearch-7", "elasticsearch-8", "opensearch-1", "opensearch-2", "opensearch-compat"} {
+
+		//setup
+		requests := []clusterStubRequest{}
+		server := newClusterStub(t, flavor, &requests)
+
+		dp := DocumentProcessor{
+			elasticsearchConfig:          ElasticsearchConfig{Addresses: []string{server.URL}, Index: "lodestone"},
+			elasticsearchIndex:           "lodestone",
+			elasticsearchMappingOverride: settingsFile.Name(),
+			logger:                       logrus.WithField("test", t.Name()),
+		}
+
+		//test
+		require.NoError(t, dp.connectElasticsearch(), flavor)
+		require.NoError(t, dp.ensureIndicies(false), flavor)
+		deleted, err := dp.deleteDocument("documents", "taxes/2019.pdf")
+		require.NoError(t, err, flavor)
+		require.Equal(t, 1, deleted, flavor)
+		server.Close()
+
+		//assert
+		expectedMediaType := "application/json"
+		if flavor == "elasticsearch-8" {
+			expectedMediaType = compatibilityMediaType
+		}
+
+		var createIndex, deleteByQuery *clusterStubRequest
+		for i, request := range requests {
+			if request.Method == http.MethodPut {
+				createIndex = &requests[i]
+			} else if strings.HasSuffix(request.Path, "/_delete_by_query") {
+				deleteByQuery = &requests[i]
+			}
+		}
+
+		require.NotNil(t, createIndex, flavor)
+		require.True(t, strings.HasPrefix(createIndex.Path, "/lodestone-"), flavor)
+		require.Equal(t, expectedMediaType, createIndex.ContentType, flavor)
+		require.Equal(t, map[string]interface{}{"properties": map[string]interface{}{
+			"created":  map[string]interface{}{"type": "date", "format": "date_optional_time"},
+			"modified": map[string]interface{}{"type": "date", "format": "strict_date_optional_time||epoch_millis"},
+		}}, createIndex.Body["mappings"], flavor)
+
+		require.NotNil(t, deleteByQuery, flavor)
+		require.Equal(t, "/lodestone/_delete_by_query", deleteByQuery.Path, flavor)
+		require.Equal(t, expectedMediaType, deleteByQuery.ContentType, flavor)
+		if flavor == "elasticsearch-8" {
+			require.Equal(t, compatibilityMediaType, deleteByQuery.Accept, flavor)
+		}
+	}
+}
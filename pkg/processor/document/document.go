@@ -1,23 +1,16 @@
 package document
 
 import (
-	"bufio"
 	"bytes"
-	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"os/user"
 	"path"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/analogj/lodestone-processor/pkg/model"
@@ -25,29 +18,108 @@ import (
 	"github.com/analogj/lodestone-processor/pkg/processor/api"
 	"github.com/analogj/lodestone-processor/pkg/version"
 	"github.com/elastic/go-elasticsearch/v7"
-	"github.com/google/go-tika/tika"
-	"github.com/markbates/pkger"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/sirupsen/logrus"
 )
 
+// number of times we'll attempt to store a document when it is being modified concurrently (eg. by the webapp)
+const storeDocumentMaxAttempts = 5
+
+// maximum number of previous versions of a document that are merged & removed when a file is re-indexed
+const previousDocumentsMax = 10
+
 type DocumentProcessor struct {
 	processor.CommonProcessor
 
 	apiEndpoint                  *url.URL
 	storageThumbnailBucket       string
-	tikaEndpoint                 *url.URL
-	elasticsearchEndpoint        *url.URL
+	tika                         *tikaPool
+	elasticsearchConfig          ElasticsearchConfig
 	elasticsearchIndex           string
+	elasticsearchFolderIndex     string
 	elasticsearchMappingOverride string
 	ocrLanguageOverride          string
+	ocrRules                     []ocrRule
+	ocrFallback                  bool
+	ocrFallbackMinQuality        float64
+	metaRawInclude               []string
+	metaRawExclude               []string
+	metadataMapping              *metadataMapping
+	tagRules                     *tagRules
+	categoryRules                *categoryRules
+	contentLimits                ContentLimits
+	detectLanguages              bool
+	nativeExtraction             bool
+	entityConfig                 EntityConfig
+	summaryConfig                SummaryConfig
+	keywordConfig                KeywordConfig
 	elasticsearchClient          *elasticsearch.Client
+	cluster                      clusterInfo
 	filter                       *model.Filter
 	logger                       *logrus.Entry
 }
 
-func CreateDocumentProcessor(logger *logrus.Entry, apiEndpoint string, storageThumbnailBucket string, tikaEndpoint string, elasticsearchEndpoint string, elasticsearchIndex string, elasticsearchMapping string, ocrLanguageOverride string) (DocumentProcessor, error) {
+// elasticsearch GET response, including the sequence number & primary term required for optimistic concurrency control
+type storedDocument struct {
+	ID          string         `json:"_id"`
+	Found       bool           `json:"found"`
+	SeqNo       int            `json:"_seq_no"`
+	PrimaryTerm int            `json:"_primary_term"`
+	Source      model.Document `json:"_source"`
+
+	//stored lodestone fields, including the ones not modelled by model.DocLodestone (eg. added by a newer webapp)
+	lodestone map[string]json.RawMessage
+}
+
+func (sd *storedDocument) UnmarshalJSON(data []byte) error {
+	type document storedDocument //without the UnmarshalJSON method
+	if err := json.Unmarshal(data, (*document)(sd)); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Source struct {
+			Lodestone map[string]json.RawMessage `json:"lodestone"`
+		} `json:"_source"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	sd.lodestone = raw.Source.Lodestone
+	return nil
+}
 
-	apiEndpointUrl, err := url.Parse(apiEndpoint)
+// DocumentProcessorConfig configures a DocumentProcessor, see CreateDocumentProcessor. The zero value of an optional
+// feature disables it.
+type DocumentProcessorConfig struct {
+	ApiEndpoint            string
+	StorageThumbnailBucket string
+
+	Tika          TikaConfig
+	Elasticsearch ElasticsearchConfig
+	Ocr           OcrConfig
+
+	// raw metadata keys (glob patterns) stored in `meta.raw`
+	MetaRawInclude []string
+	MetaRawExclude []string
+
+	// tika metadata -> document field mapping, defaults to static/document-processor/metadata_mapping.json
+	MetadataMappingPath string
+
+	// rules used to derive lodestone.tags, by default every folder becomes a tag
+	TagRulesPath string
+
+	ContentLimits    ContentLimits
+	DetectLanguages  bool
+	NativeExtraction bool //extract simple text formats without tika
+	Entities         EntityConfig
+	Classification   ClassificationConfig
+	Summary          SummaryConfig
+	Keywords         KeywordConfig
+}
+
+func CreateDocumentProcessor(logger *logrus.Entry, config DocumentProcessorConfig) (DocumentProcessor, error) {
+	apiEndpointUrl, err := url.Parse(config.ApiEndpoint)
 	if err != nil {
 		return DocumentProcessor{}, err
 	}
@@ -58,44 +130,106 @@ func CreateDocumentProcessor(logger *logrus.Entry, apiEndpoint string, storageTh
 		return DocumentProcessor{}, err
 	}
 
-	tikaEndpointUrl, err := url.Parse(tikaEndpoint)
+	tikaServers, err := newTikaPool(config.Tika, logger)
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	err = config.Elasticsearch.Validate()
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	err = config.Ocr.Validate()
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	ocrRules, err := loadOcrRules(config.Ocr.RulesPath)
 	if err != nil {
 		return DocumentProcessor{}, err
 	}
 
-	elasticsearchEndpointUrl, err := url.Parse(elasticsearchEndpoint)
+	err = config.ContentLimits.Validate()
 	if err != nil {
 		return DocumentProcessor{}, err
 	}
 
+	err = config.Summary.Validate()
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	err = config.Keywords.Validate()
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	//load the tika metadata -> document field mapping (or the embedded default)
+	metadataMapping, err := loadMetadataMapping(config.MetadataMappingPath)
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	tagRules, err := loadTagRules(config.TagRulesPath)
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
+
+	var categoryRules *categoryRules
+	if config.Classification.Enabled {
+		categoryRules, err = loadCategoryRules(config.Classification.RulesPath)
+		if err != nil {
+			return DocumentProcessor{}, err
+		}
+	}
+
 	dp := DocumentProcessor{
 		apiEndpoint:                  apiEndpointUrl,
-		storageThumbnailBucket:       storageThumbnailBucket,
-		tikaEndpoint:                 tikaEndpointUrl,
-		elasticsearchEndpoint:        elasticsearchEndpointUrl,
-		elasticsearchIndex:           elasticsearchIndex,
-		elasticsearchMappingOverride: elasticsearchMapping,
-		ocrLanguageOverride:          ocrLanguageOverride,
+		storageThumbnailBucket:       config.StorageThumbnailBucket,
+		tika:                         tikaServers,
+		elasticsearchConfig:          config.Elasticsearch,
+		elasticsearchIndex:           config.Elasticsearch.Index,
+		elasticsearchFolderIndex:     config.Elasticsearch.folderIndex(),
+		elasticsearchMappingOverride: config.Elasticsearch.MappingOverride,
+		ocrLanguageOverride:          config.Ocr.LanguageOverride,
+		ocrRules:                     ocrRules,
+		ocrFallback:                  config.Ocr.Fallback,
+		ocrFallbackMinQuality:        config.Ocr.FallbackMinQuality,
+		metaRawInclude:               config.MetaRawInclude,
+		metaRawExclude:               config.MetaRawExclude,
+		metadataMapping:              metadataMapping,
+		tagRules:                     tagRules,
+		categoryRules:                categoryRules,
+		contentLimits:                config.ContentLimits,
+		detectLanguages:              config.DetectLanguages,
+		nativeExtraction:             config.NativeExtraction,
+		entityConfig:                 config.Entities,
+		summaryConfig:                config.Summary,
+		keywordConfig:                config.Keywords,
 		filter:                       &filterData,
 		logger:                       logger,
 	}
 
-	//ensure the elastic search index exists (do this once on startup)
-	cfg := elasticsearch.Config{
-		Addresses: []string{dp.elasticsearchEndpoint.String()},
+	//documents will not be processed until a tika server is available
+	if healthy := dp.tika.checkHealth(); healthy == 0 {
+		dp.logger.Warnln("No healthy Tika servers available on startup")
+	} else {
+		dp.logger.Printf("%d of %d Tika servers are healthy", healthy, len(config.Tika.Endpoints))
 	}
 
-	es, err := elasticsearch.NewClient(cfg)
+	//ensure the elastic search index exists (do this once on startup)
+	err = dp.connectElasticsearch()
 	if err != nil {
 		return DocumentProcessor{}, err
 	}
-	dp.elasticsearchClient = es
 
-	dp.logger.Debugln("Connect to ElasticSearch & ensure indicies exist")
-	dp.logger.Debugln(elasticsearch.Version)
-	dp.logger.Debugln(es.Info())
+	err = dp.ensureIndicies(false)
+	if err != nil {
+		return DocumentProcessor{}, err
+	}
 
-	err = dp.ensureIndicies()
+	err = dp.ensureFolderIndex()
 	if err != nil {
 		return DocumentProcessor{}, err
 	}
@@ -103,6 +237,52 @@ func CreateDocumentProcessor(logger *logrus.Entry, apiEndpoint string, storageTh
 	return dp, nil
 }
 
+// MigrateIndex creates a new physical index using the current index settings, reindexes all documents into it, and
+// swaps the alias. When force is false, this is a no-op if the alias already points to an up-to-date index.
+func MigrateIndex(logger *logrus.Entry, elasticsearchConfig ElasticsearchConfig, metadataMappingPath string, force bool) error {
+	//the custom metadata fields are part of the index mapping
+	metadataMapping, err := loadMetadataMapping(metadataMappingPath)
+	if err != nil {
+		return err
+	}
+
+	dp := DocumentProcessor{
+		elasticsearchConfig:          elasticsearchConfig,
+		elasticsearchIndex:           elasticsearchConfig.Index,
+		elasticsearchMappingOverride: elasticsearchConfig.MappingOverride,
+		metadataMapping:              metadataMapping,
+		logger:                       logger,
+	}
+
+	err = dp.connectElasticsearch()
+	if err != nil {
+		return err
+	}
+	return dp.ensureIndicies(force)
+}
+
+func (dp *DocumentProcessor) connectElasticsearch() error {
+	es, err := newElasticsearchClient(dp.elasticsearchConfig)
+	if err != nil {
+		return err
+	}
+
+	dp.logger.Debugln("Connect to ElasticSearch & ensure indicies exist")
+	dp.logger.Debugln(elasticsearch.Version)
+	cluster, err := detectCluster(es)
+	if err != nil {
+		return err
+	}
+	dp.logger.Printf("Connected to %s %s", cluster.Flavor, cluster.Version)
+
+	if cluster.requiresCompatibilityHeaders() {
+		es = withCompatibilityHeaders(es)
+	}
+	dp.elasticsearchClient = es
+	dp.cluster = cluster
+	return nil
+}
+
 func (dp *DocumentProcessor) Process(body []byte) error {
 
 	var event model.S3Event
@@ -130,298 +310,498 @@ func (dp *DocumentProcessor) Process(body []byte) error {
 	}
 	defer os.RemoveAll(dir) // clean up
 
-	if event.Records[0].EventName == "s3:ObjectRemoved:Delete" {
+	if event.Records[0].EventName == "s3:ObjectRemoved:Delete" && strings.HasSuffix(docBucketPath, "/") {
+		//a folder was removed, delete every document (and folder) it contains
+		folder := strings.Trim(docBucketPath, "/")
+		deleted, err := dp.deleteFolder(docBucketName, folder)
+		if err != nil {
+			return err
+		}
+		dp.logger.Infof("Deleted %d documents in folder (%s, %s)", deleted, docBucketName, docBucketPath)
+
+		err = dp.deleteFolderTree(docBucketName, folder)
+		if err != nil {
+			return err
+		}
+		return dp.pruneFolders(docBucketName, storageFolder(folder))
+	} else if event.Records[0].EventName == "s3:ObjectRemoved:Delete" {
 		dp.logger.Debugln("Attempting to delete file")
 
 		//delete document in Elasticsearch
-		err = dp.deleteDocument(docBucketName, docBucketPath)
+		deleted, err := dp.deleteDocument(docBucketName, docBucketPath)
 		if err != nil {
 			return err
 		}
-		return nil
+		dp.logger.Infof("Deleted %d documents (%s, %s)", deleted, docBucketName, docBucketPath)
+
+		//remove the folders that no longer contain any documents
+		return dp.pruneFolders(docBucketName, storageFolder(docBucketPath))
 	} else {
 
-		filePath, err := api.ReadFile(dp.apiEndpoint, docBucketName, docBucketPath, dir)
+		//circuit breaker, stop consuming messages while tika is unavailable
+		if dp.requiresTika(docBucketPath, "") {
+			dp.tika.waitUntilHealthy()
+		}
+
+		//the document is written to disk & hashed while it is streamed to TIKA. The original file attributes are stored
+		//with the object, as S3 user metadata (response headers)
+		fileBody, fileHeader, err := api.OpenFile(dp.apiEndpoint, docBucketName, docBucketPath)
 		if err != nil {
 			return err
 		}
-		if dp.IsEmptyFile(filePath) {
+		source, err := downloadDocumentSource(fileBody, filepath.Join(dir, filepath.Base(docBucketPath)))
+		if err != nil {
+			return err
+		}
+		source.attributes = dp.fileAttributes(event.Records[0], fileHeader)
+		defer source.wait()
+		if source.empty() {
 			dp.logger.Infof("Ignoring document, filesize is 0 (%s, %s)", docBucketName, docBucketPath)
 			return nil
 		}
 
 		//pass document to TIKA
-		doc, err := dp.parseDocument(docBucketName, docBucketPath, filePath)
+		docs, err := dp.parseDocument(docBucketName, docBucketPath, source)
 		if err != nil {
 			return err
 		}
 
-		//store document in Elasticsearch
-		err = dp.storeDocument(doc)
+		//store document (and its embedded documents) in Elasticsearch
+		for _, doc := range docs {
+			err = dp.storeDocument(doc)
+			if err != nil {
+				return err
+			}
+		}
+
+		//remove embedded documents & chunks that belonged to a previous version of this file
+		err = dp.deleteStaleChildDocuments(docs)
 		if err != nil {
 			return err
 		}
-	}
 
-	return nil
-}
-func (dp *DocumentProcessor) tikaHttpClient() *http.Client {
-	client := &http.Client{
-		Timeout:   time.Minute * 5, //5 minutes max to process documents.
-		Transport: TikaRoundTripper{r: http.DefaultTransport, ocrLanguageOverride: dp.ocrLanguageOverride},
+		//make sure the folder of the document (and its ancestors) can be browsed
+		err = dp.storeFolders(docBucketName, storageFolder(docBucketPath))
+		if err != nil {
+			return err
+		}
 	}
 
-	return client
+	return nil
 }
 
-func (dp *DocumentProcessor) parseDocument(bucketName string, bucketPath string, localFilePath string) (model.Document, error) {
-
-	docFile, err := os.Open(localFilePath)
-	if err != nil {
-		return model.Document{}, err
-	}
-	defer docFile.Close()
-
-	client := tika.NewClient(dp.tikaHttpClient(), dp.tikaEndpoint.String())
-	docContent, err := client.Parse(context.Background(), docFile)
-	if err != nil {
-		return model.Document{}, err
-	}
-	//trim whitespace/newline characters
-	docContent = strings.TrimSpace(docContent)
-	dp.logger.Debugf("docContent: '%s'", docContent)
-
-	metaFile, err := os.Open(localFilePath)
-	if err != nil {
-		return model.Document{}, err
-	}
-	defer metaFile.Close()
+func (dp *DocumentProcessor) parseDocument(bucketName string, bucketPath string, source *documentSource) ([]model.Document, error) {
 
-	metaJson, err := client.Meta(context.Background(), metaFile)
+	contentType := detectContentType(bucketPath, source.head)
+	ocr := dp.ocrSettingsFor(bucketPath, contentType)
+	resources, extractionMethod, err := dp.extract(bucketPath, contentType, source, ocr)
 	if err != nil {
-		return model.Document{}, err
+		return nil, err
 	}
-	dp.logger.Debugf("metaJson: %s", metaJson)
 
-	fileStat, err := os.Stat(localFilePath)
-	if err != nil {
-		return model.Document{}, err
+	//extractors may stop reading before the end of the file (eg. when it is truncated), the checksum & size are only
+	//available once the rest of the download has been written to disk
+	if err := source.wait(); err != nil {
+		return nil, err
 	}
-
-	shaFile, err := os.Open(localFilePath)
+	fileStat, err := os.Stat(source.localFilePath)
 	if err != nil {
-		return model.Document{}, err
+		return nil, err
 	}
-	defer shaFile.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, shaFile); err != nil {
-		return model.Document{}, err
+	fileTruncated := dp.contentLimits.TikaMaxBytes > 0 && source.size > dp.contentLimits.TikaMaxBytes
+	if extractionMethod == extractionMethodTika && ocr.Strategy == ocrStrategyOcrOnly {
+		extractionMethod = extractionMethodTikaOcr
 	}
-	sha256Checksum := hex.EncodeToString(hasher.Sum(nil))
 
-	sysStat := fileStat.Sys().(*syscall.Stat_t)
-	AccessedTime := time.Unix(int64(sysStat.Atim.Sec), int64(sysStat.Atim.Nsec))
-	CreatedTime := time.Unix(int64(sysStat.Ctim.Sec), int64(sysStat.Ctim.Nsec))
+	//convert to plain text & trim whitespace/newline characters
+	docContent, docPages := resourceContent(resources[0])
+	docQuality := textQuality(docContent)
 
-	grp, err := user.LookupGroupId(fmt.Sprintf("%d", sysStat.Gid))
-	grpName := ""
-	if err == nil {
-		grpName = grp.Name
-	}
-
-	usr, err := user.LookupGroupId(fmt.Sprintf("%d", sysStat.Uid))
-	usrName := ""
-	if err == nil {
-		usrName = usr.Name
+	//PDFs without a (usable) text layer are resent with OCR forced, we keep whichever result has the better quality.
+	if extractionMethod == extractionMethodTika && dp.needsOcrFallback(castToString(resourceMetadata(resources[0])["Content-Type"]), docQuality, ocr) {
+		dp.logger.Infof("Text quality is %.2f, retrying with OCR (%s)", docQuality, bucketPath)
+		ocr.Strategy = ocrStrategyOcrOnly
+		ocrResources, err := dp.tikaExtractor().Extract(bucketPath, source, ocr)
+		if err != nil {
+			dp.logger.Warnf("An error occurred while retrying with OCR, keeping extracted text: %v", err)
+		} else if ocrContent, ocrPages := resourceContent(ocrResources[0]); textQuality(ocrContent) > docQuality {
+			resources, docContent, docPages, docQuality = ocrResources, ocrContent, ocrPages, textQuality(ocrContent)
+			extractionMethod = extractionMethodTikaOcr
+		}
 	}
+	docContentPreview, _ := truncateText(docContent, debugContentPreviewChars)
+	dp.logger.Debugf("docContent (%d bytes): '%s'", len(docContent), docContentPreview)
+	dp.logger.Debugf("embedded resources: %d", len(resources)-1)
 
-	//convert the filepath into "tags"
-	bucketPathDir, _ := filepath.Split(bucketPath)
-	bucketPathTags := strings.Split(bucketPathDir, "/")
+	sha256Checksum := source.checksum
 
 	doc := model.Document{
 		//ID length limit is 512 bytes, cant use path or base64 here. instead we'll use the document checksum value.
 		ID:      sha256Checksum,
 		Content: docContent, //make sure that empty content is stored as ""
+		Pages:   docPages,
 		Lodestone: model.DocLodestone{
 			ProcessorVersion: version.VERSION,
-			Title:            "",
-			Tags:             deleteEmpty(bucketPathTags),
 			Bookmark:         false,
 		},
 		File: model.DocFile{
-			FileName:     fileStat.Name(),
-			Extension:    strings.ToLower(strings.TrimPrefix(path.Ext(fileStat.Name()), ".")),
-			Filesize:     fileStat.Size(),
-			IndexedChars: int64(len(docContent)),
-			IndexedDate:  time.Now(),
-
-			Created:      CreatedTime,
-			LastModified: fileStat.ModTime(),
-			LastAccessed: AccessedTime,
+			FileName:         fileStat.Name(),
+			Extension:        strings.ToLower(strings.TrimPrefix(path.Ext(fileStat.Name()), ".")),
+			Filesize:         fileStat.Size(),
+			IndexedChars:     int64(len(docContent)),
+			Truncated:        fileTruncated,
+			ExtractionMethod: extractionMethod,
+			TextQuality:      docQuality,
+			IndexedDate:      time.Now(),
+
+			Created:      optionalTime(source.attributes.Changed),
+			LastModified: optionalTime(source.attributes.Modified),
+			LastAccessed: optionalTime(source.attributes.Accessed),
 			Checksum:     sha256Checksum,
 
-			Group: grpName,
-			Owner: usrName,
+			Group: source.attributes.Group,
+			Owner: source.attributes.Owner,
+			Mode:  source.attributes.ModeString(),
 		},
 		Storage: model.DocStorage{
 			Path:        bucketPath,
+			Folder:      storageFolder(bucketPath),
+			FolderTree:  folderTree(storageFolder(bucketPath)),
 			Bucket:      bucketName,
 			ThumbBucket: dp.storageThumbnailBucket,
 			ThumbPath:   api.GenerateThumbnailStoragePath(bucketPath),
 		},
 	}
 
-	err = dp.parseTikaMetadata(metaJson, &doc)
-	return doc, err
+	err = dp.applyTikaMetadata(resourceMetadata(resources[0]), &doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Meta.Pages == 0 {
+		doc.Meta.Pages = len(docPages)
+	}
+	doc.Lodestone.Title, doc.Lodestone.TitleSource = generateTitle(doc)
+	//convert the filepath (and metadata) into "tags"
+	doc.Lodestone.Tags = dp.tagRules.tags(bucketPath, doc.File.ContentType, doc.Meta.Keywords)
+	dp.detectLanguage(&doc)
+	dp.extractEntities(&doc)
+	dp.summarize(&doc)
+	dp.extractKeywords(&doc)
+	dp.classifyDocument(&doc)
+
+	docs := dp.applyContentLimits(doc)
+	for _, resource := range resources[1:] {
+		child, err := dp.embeddedDocument(doc, resource)
+		if err != nil {
+			return nil, err
+		}
+		child.File.ExtractionMethod = extractionMethod
+		child.Lodestone.Title, child.Lodestone.TitleSource = generateTitle(child)
+		dp.detectLanguage(&child)
+		dp.extractEntities(&child)
+		dp.summarize(&child)
+		dp.extractKeywords(&child)
+		dp.classifyDocument(&child)
+		docs = append(docs, dp.applyContentLimits(child)...)
+	}
+	return docs, nil
 }
 
-//store document in elasticsearch
+// store document in elasticsearch
+//
+// The processor only owns the content, file, meta and storage sections of a document. The lodestone section is
+// curated by users in the webapp (title, tags, bookmark), so when a document is re-indexed we merge with the currently
+// stored version, and write it back using optimistic concurrency control. If the webapp modified the document between
+// our read and our write, Elasticsearch will reject the write with a 409 and we retry with the fresh copy.
+//
+// Document IDs are derived from the file content, so when the content of a file changes the document is stored under a
+// new ID. The previous version is found using its storage path instead: its lodestone section is merged into the new
+// document, and it is removed once the new document is stored.
 func (dp *DocumentProcessor) storeDocument(document model.Document) error {
 	// use https://github.com/elastic/go-elasticsearch
-	// first migrate capsulecd to support mod
+	generated := document.Lodestone
 
-	payload, err := json.Marshal(document)
-	if err != nil {
-		dp.logger.Printf("An error occurred while json encoding Document: %v", err)
-		return err
-	}
+	for attempt := 1; attempt <= storeDocumentMaxAttempts; attempt++ {
+		existing, err := dp.getStoredDocument(document.ID)
+		if err != nil {
+			dp.logger.Printf("An error occurred while retrieving existing document: %v", err)
+			return err
+		}
 
-	dp.logger.Println("Attempting to store new document in elasticsearch")
-	esResp, err := dp.elasticsearchClient.Index(dp.elasticsearchIndex, bytes.NewReader(payload), dp.elasticsearchClient.Index.WithDocumentID(document.ID))
-	dp.logger.Debugf("DEBUG: ES response: %v", esResp)
-	if err != nil {
-		dp.logger.Printf("An error occurred while storing document: %v", err)
-	}
+		var previous []storedDocument
+		if document.ParentID == "" {
+			previous, err = dp.getPreviousDocuments(document)
+			if err != nil {
+				dp.logger.Printf("An error occurred while retrieving previous versions of document: %v", err)
+				return err
+			}
+		}
 
-	return err
-}
+		indexOptions := []func(*esapi.IndexRequest){
+			dp.elasticsearchClient.Index.WithDocumentID(document.ID),
+		}
+		curated := model.DocLodestone{}
+		var stored map[string]json.RawMessage
+		if existing == nil {
+			//document does not exist yet, make sure we dont overwrite a document created concurrently.
+			indexOptions = append(indexOptions, dp.elasticsearchClient.Index.WithOpType("create"))
+		} else {
+			curated, stored = existing.Source.Lodestone, existing.lodestone
+			indexOptions = append(indexOptions,
+				dp.elasticsearchClient.Index.WithIfSeqNo(existing.SeqNo),
+				dp.elasticsearchClient.Index.WithIfPrimaryTerm(existing.PrimaryTerm),
+			)
+		}
+		if len(previous) > 0 {
+			//the previous version is the one users see (and edit) until it is replaced
+			curated, stored = previous[0].Source.Lodestone, previous[0].lodestone
+		}
+		document.Lodestone = mergeLodestone(curated, generated)
 
-//deletee document in elasticsearch
-func (dp *DocumentProcessor) deleteDocument(docBucketName string, docBucketPath string) error {
-	// use https://github.com/elastic/go-elasticsearch
+		payload, err := documentPayload(document, stored)
+		if err != nil {
+			dp.logger.Printf("An error occurred while json encoding Document: %v", err)
+			return err
+		}
 
-	dp.logger.Println("Attempting to delete document by query in elasticsearch")
-	esResp, err := dp.elasticsearchClient.DeleteByQuery([]string{dp.elasticsearchIndex}, strings.NewReader(
-		fmt.Sprintf(`
-			{
-				"query": {
-					"bool": {
-						"must": [
-							{ "match": { "storage.bucket": "%v" }},
-							{ "match": { "storage.path": "%v"}}
-						]
-					}
-				}
-			}`, docBucketName, docBucketPath)))
-	dp.logger.Debugf("DEBUG: ES response: %v", esResp)
-	if err != nil {
-		dp.logger.Printf("An error occurred while deleting document: %v", err)
-	}
-	return err
-}
+		dp.logger.Println("Attempting to store document in elasticsearch")
+		esResp, err := dp.elasticsearchClient.Index(dp.elasticsearchIndex, bytes.NewReader(payload), indexOptions...)
+		dp.logger.Debugf("DEBUG: ES response: %v", esResp)
+		if err != nil {
+			dp.logger.Printf("An error occurred while storing document: %v", err)
+			return err
+		}
+		esResp.Body.Close()
 
-func (dp *DocumentProcessor) ensureIndicies() error {
-	//we cant be sure that the elasticsearch index (& mappings) already exist, so we have to check if they exist on every document insertion.
+		if esResp.StatusCode == http.StatusConflict {
+			dp.logger.Printf("Document was modified concurrently, retrying (%d/%d)", attempt, storeDocumentMaxAttempts)
+			continue
+		} else if esResp.IsError() {
+			return fmt.Errorf("an error occurred while storing document: %s", esResp.Status())
+		}
 
-	dp.logger.Printf("Attempting to create %s index, if it does not exist", dp.elasticsearchIndex)
-	resp, err := dp.elasticsearchClient.Indices.Exists([]string{dp.elasticsearchIndex})
-	dp.logger.Debugf("%v \n %v", resp, err)
-	if err == nil && resp.StatusCode == 200 {
-		//index exists, do nothing
-		dp.logger.Println("Index already exists, skipping.")
+		modified, err := dp.deletePreviousDocuments(previous)
+		if err != nil {
+			return err
+		}
+		if modified {
+			//the webapp modified the previous version after we read it, merge its changes again
+			dp.logger.Printf("Previous version of document was modified concurrently, retrying (%d/%d)", attempt, storeDocumentMaxAttempts)
+			continue
+		}
 		return nil
 	}
 
-	//index does not exist, lets create it
-	var indexSettingsFile io.ReadCloser
+	return fmt.Errorf("could not store document %s, it was modified concurrently %d times", document.ID, storeDocumentMaxAttempts)
+}
 
-	if dp.elasticsearchMappingOverride == "" {
-		indexSettingsFile, err = pkger.Open("/static/document-processor/settings.json")
-	} else {
-		indexSettingsFile, err = os.Open(dp.elasticsearchMappingOverride)
+// retrieve the currently stored version of a document (with its concurrency control values), or nil if it does not exist
+// documentPayload json encodes the document, keeping the stored lodestone fields that are not modelled by
+// model.DocLodestone, as the index request replaces the whole document.
+func documentPayload(document model.Document, stored map[string]json.RawMessage) ([]byte, error) {
+	payload, err := json.Marshal(document)
+	if err != nil || len(stored) == 0 {
+		return payload, err
 	}
 
+	lodestoneJson, err := json.Marshal(document.Lodestone)
 	if err != nil {
-		dp.logger.Printf("COULD NOT OPEN MAPPING OVERRIDE FILE: %v", err)
-		return err
+		return nil, err
+	}
+	lodestone := map[string]json.RawMessage{}
+	if err := json.Unmarshal(lodestoneJson, &lodestone); err != nil {
+		return nil, err
 	}
+	for field, value := range stored {
+		if !containsString(lodestoneFields, field) {
+			lodestone[field] = value
+		}
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	if fields["lodestone"], err = json.Marshal(lodestone); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func (dp *DocumentProcessor) getStoredDocument(documentId string) (*storedDocument, error) {
+	esResp, err := dp.elasticsearchClient.Get(dp.elasticsearchIndex, documentId)
+	if err != nil {
+		return nil, err
+	}
+	defer esResp.Body.Close()
 
-	defer indexSettingsFile.Close()
-	mappingReader := bufio.NewReader(indexSettingsFile)
+	if esResp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if esResp.IsError() {
+		return nil, fmt.Errorf("an error occurred while retrieving document: %s", esResp.Status())
+	}
 
-	_, err = dp.elasticsearchClient.Indices.Create(dp.elasticsearchIndex, dp.elasticsearchClient.Indices.Create.WithBody(mappingReader))
-	return err
+	var existing storedDocument
+	err = json.NewDecoder(esResp.Body).Decode(&existing)
+	if err != nil {
+		return nil, err
+	}
+	if !existing.Found {
+		return nil, nil
+	}
+	return &existing, nil
 }
 
-func (dp *DocumentProcessor) parseTikaMetadata(metaJson string, doc *model.Document) error {
-	var parsedMeta map[string]interface{}
-	err := json.Unmarshal([]byte(metaJson), &parsedMeta)
+// getPreviousDocuments retrieves the documents stored for the same file under a different ID (ie. previous versions of
+// the file content), most recently indexed first.
+func (dp *DocumentProcessor) getPreviousDocuments(document model.Document) ([]storedDocument, error) {
+	var query bytes.Buffer
+	err := json.NewEncoder(&query).Encode(map[string]interface{}{
+		"seq_no_primary_term": true,
+		"size":                previousDocumentsMax,
+		"sort":                []interface{}{map[string]interface{}{"file.indexed_date": map[string]interface{}{"order": "desc"}}},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"storage.bucket": document.Storage.Bucket}},
+					map[string]interface{}{"term": map[string]interface{}{"storage.path": document.Storage.Path}},
+				},
+				"must_not": []interface{}{
+					map[string]interface{}{"exists": map[string]interface{}{"field": "parent_id"}},
+					map[string]interface{}{"ids": map[string]interface{}{"values": []string{document.ID}}},
+				},
+			},
+		},
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	doc.File.ContentType = dp.findString(parsedMeta, "Content-Type", "content-type")
-	doc.Meta = model.DocMeta{
-		Author:      dp.findString(parsedMeta, "Author", "meta:author"),
-		Date:        dp.findTime(parsedMeta, "Date"),
-		CreatedDate: dp.findTime(parsedMeta, "created", "Creation-Date", "meta:creation-date", "dcterms:created", "pdf:docinfo:created"),
-		SavedDate:   dp.findTime(parsedMeta, "Last-Save-Date", "meta:save-date"),
-
-		Keywords:    dp.findStringArray(parsedMeta, "Keywords", "meta:keyword", "pdf:docinfo:keywords"),
-		Title:       dp.findString(parsedMeta, "title", "dc:title", "cp:subject", "pdf:docinfo:title"),
-		Language:    dp.findString(parsedMeta, "language"),
-		Format:      dp.findString(parsedMeta, "dc:format"),
-		Identifier:  dp.findString(parsedMeta, "identifier"),
-		Contributor: dp.findString(parsedMeta, "contributor"),
-		Modifier:    dp.findString(parsedMeta, "modifier"),
-		CreatorTool: dp.findString(parsedMeta, "pdf:docinfo:creator_tool", "xmp:CreatorTool"),
-		Publisher:   dp.findString(parsedMeta, "publisher"),
-		Relation:    dp.findString(parsedMeta, "relation"),
-		Rights:      dp.findString(parsedMeta, "rights"),
-		Source:      dp.findString(parsedMeta, "source"),
-		Type:        dp.findString(parsedMeta, "type"),
-		Description: dp.findString(parsedMeta, "description", "subject", "dc:description", "cp:subject", "pdf:docinfo:subject"),
-		Latitude:    dp.findString(parsedMeta, "latitude", "Latitude"),
-		Longitude:   dp.findString(parsedMeta, "longitude", "Longitude"),
-		Altitude:    dp.findString(parsedMeta, "altitude"),
-		//Rating       byte      `json:"rating"`
-		Comments: dp.findString(parsedMeta, "comments"),
-		Pages:    dp.findString(parsedMeta, "xmpTPg:NPages"),
+	esResp, err := dp.elasticsearchClient.Search(
+		dp.elasticsearchClient.Search.WithIndex(dp.elasticsearchIndex),
+		dp.elasticsearchClient.Search.WithBody(&query),
+	)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer esResp.Body.Close()
+	if esResp.IsError() {
+		return nil, fmt.Errorf("an error occurred while retrieving previous versions of document: %s", esResp.String())
+	}
+
+	var searchResult struct {
+		Hits struct {
+			Hits []storedDocument `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(esResp.Body).Decode(&searchResult); err != nil {
+		return nil, err
+	}
+	return searchResult.Hits.Hits, nil
 }
 
-func (dp *DocumentProcessor) findTime(dict map[string]interface{}, keys ...string) time.Time {
-	for _, v := range keys {
-		val := castToTime(dict[v])
-		if !val.IsZero() {
-			return val
+// deletePreviousDocuments removes the previous versions of a document, unless they were modified since they were read.
+// Returns true if any of them was modified.
+func (dp *DocumentProcessor) deletePreviousDocuments(previous []storedDocument) (bool, error) {
+	modified := false
+	for _, doc := range previous {
+		dp.logger.Debugf("Removing previous version of document (%s)", doc.ID)
+		esResp, err := dp.elasticsearchClient.Delete(dp.elasticsearchIndex, doc.ID,
+			dp.elasticsearchClient.Delete.WithIfSeqNo(doc.SeqNo),
+			dp.elasticsearchClient.Delete.WithIfPrimaryTerm(doc.PrimaryTerm),
+		)
+		if err != nil {
+			return modified, err
+		}
+		esResp.Body.Close()
+
+		if esResp.StatusCode == http.StatusConflict {
+			modified = true
+		} else if esResp.IsError() && esResp.StatusCode != http.StatusNotFound {
+			return modified, fmt.Errorf("an error occurred while removing previous version of document %s: %s", doc.ID, esResp.Status())
 		}
 	}
+	return modified, nil
+}
 
-	return time.Time{}
+// deleteDocument removes the document stored at the path, including its embedded documents & content chunks. Returns
+// the number of deleted documents.
+func (dp *DocumentProcessor) deleteDocument(docBucketName string, docBucketPath string) (int, error) {
+	dp.logger.Println("Attempting to delete document by query in elasticsearch")
+	return dp.deleteByQuery(dp.elasticsearchIndex, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"storage.bucket": docBucketName}},
+				map[string]interface{}{"term": map[string]interface{}{"storage.path": docBucketPath}},
+			},
+		},
+	}, true)
 }
 
-func (dp *DocumentProcessor) findStringArray(dict map[string]interface{}, keys ...string) []string {
-	for _, v := range keys {
-		val := castToStringArray(dict[v])
-		if val != nil {
-			return val
-		}
+// deleteFolder removes every document stored in the folder (or any of its subfolders). Returns the number of deleted
+// documents.
+func (dp *DocumentProcessor) deleteFolder(docBucketName string, folder string) (int, error) {
+	if folder == "" {
+		return 0, fmt.Errorf("refusing to delete all documents in the %s bucket", docBucketName)
 	}
 
-	return []string{}
+	dp.logger.Printf("Attempting to delete all documents in folder %s/%s", docBucketName, folder)
+	return dp.deleteByQuery(dp.elasticsearchIndex, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"storage.bucket": docBucketName}},
+				map[string]interface{}{"prefix": map[string]interface{}{"storage.path": folder + "/"}},
+			},
+		},
+	}, true)
 }
 
-func (dp *DocumentProcessor) findString(dict map[string]interface{}, keys ...string) string {
-	for _, v := range keys {
-		val := castToString(dict[v])
-		if val != "" {
-			return val
-		}
+// deleteByQuery removes the documents matching the query from the index, and returns the number of deleted documents.
+// The query is JSON encoded, so user provided values (eg. paths) cannot change its structure. When refresh is true,
+// the deletes are visible to searches once deleteByQuery returns.
+func (dp *DocumentProcessor) deleteByQuery(index string, query map[string]interface{}, refresh bool) (int, error) {
+	payload, err := json.Marshal(map[string]interface{}{"query": query})
+	if err != nil {
+		return 0, err
+	}
+
+	esResp, err := dp.elasticsearchClient.DeleteByQuery([]string{index}, bytes.NewReader(payload),
+		dp.elasticsearchClient.DeleteByQuery.WithRefresh(refresh),
+	)
+	if err != nil {
+		dp.logger.Printf("An error occurred while deleting documents: %v", err)
+		return 0, err
+	}
+	defer esResp.Body.Close()
+	if esResp.IsError() {
+		return 0, fmt.Errorf("an error occurred while deleting documents from %s: %s", index, esResp.String())
+	}
+
+	var deleteResult struct {
+		Deleted  int               `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
 	}
+	if err := json.NewDecoder(esResp.Body).Decode(&deleteResult); err != nil {
+		return 0, err
+	}
+	if len(deleteResult.Failures) > 0 {
+		return deleteResult.Deleted, fmt.Errorf("%d documents could not be deleted from %s: %s", len(deleteResult.Failures), index, deleteResult.Failures[0])
+	}
+	return deleteResult.Deleted, nil
+}
 
-	return ""
+func (dp *DocumentProcessor) parseTikaMetadata(metaJson string, doc *model.Document) error {
+	var parsedMeta map[string]interface{}
+	err := json.Unmarshal([]byte(metaJson), &parsedMeta)
+	if err != nil {
+		return err
+	}
+
+	return dp.applyTikaMetadata(parsedMeta, doc)
+}
+
+func (dp *DocumentProcessor) applyTikaMetadata(parsedMeta map[string]interface{}, doc *model.Document) error {
+	dp.metadataMapping.apply(parsedMeta, doc)
+	doc.Meta.Raw = dp.rawMetadata(parsedMeta)
+	return nil
 }
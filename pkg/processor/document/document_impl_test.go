@@ -1,8 +1,17 @@
 package document
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -10,11 +19,13 @@ import (
 func TestDocumentProcessor_Process(t *testing.T) {
 
 	//setup
-	proc := DocumentProcessor{}
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{metadataMapping: metadataMapping}
 	docModel := model.Document{}
 
 	//test
-	err := proc.parseTikaMetadata(`{
+	err = proc.parseTikaMetadata(`{
 		"Accessibility": "structured; tagged",
 		"Author": "SE:W:CAR:MP",
 		"Content-Type": "application/pdf",
@@ -106,7 +117,7 @@ func TestDocumentProcessor_Process(t *testing.T) {
 			Keywords:    []string{"Fillable"},
 			Title:       "2018 Form 4868",
 			Language:    "en",
-			Format:      "",
+			Format:      "application/pdf; version=1.7, application/pdf; version=\"1.7 Adobe Extension Level 5\"",
 			Identifier:  "",
 			Contributor: "",
 			Modifier:    "",
@@ -122,8 +133,314 @@ func TestDocumentProcessor_Process(t *testing.T) {
 			Altitude:    "",
 			Rating:      0x0,
 			Comments:    "",
-			Pages:       "4",
+			Pages:       4,
+			Raw: map[string]interface{}{
+				"Accessibility":                               "structured; tagged",
+				"Author":                                      "SE:W:CAR:MP",
+				"Content-Type":                                "application/pdf",
+				"Creation-Date":                               "2018-10-24T18:27:15Z",
+				"Form_fields":                                 "fillable",
+				"Keywords":                                    "Fillable",
+				"Last-Modified":                               "2018-10-24T18:27:15Z",
+				"Last-Save-Date":                              "2018-10-24T18:27:15Z",
+				"X-Parsed-By":                                 []string{"org.apache.tika.parser.DefaultParser", "org.apache.tika.parser.pdf.PDFParser"},
+				"access_permission:assemble_document":         "true",
+				"access_permission:can_modify":                "true",
+				"access_permission:can_print":                 "true",
+				"access_permission:can_print_degraded":        "true",
+				"access_permission:extract_content":           "true",
+				"access_permission:extract_for_accessibility": "true",
+				"access_permission:fill_in_form":              "true",
+				"access_permission:modify_annotations":        "true",
+				"cp:subject":                                  "Application for Automatic Extension of Time To File U.S. Individual Income Tax Return",
+				"created":                                     "2018-10-24T18:27:15Z",
+				"creator":                                     "SE:W:CAR:MP",
+				"date":                                        "2018-10-24T18:27:15Z",
+				"dc:creator":                                  "SE:W:CAR:MP",
+				"dc:description":                              "Application for Automatic Extension of Time To File U.S. Individual Income Tax Return",
+				"dc:format":                                   []string{"application/pdf; version=1.7", "application/pdf; version=\"1.7 Adobe Extension Level 5\""},
+				"dc:subject":                                  "Fillable",
+				"dc:title":                                    "2018 Form 4868",
+				"dcterms:created":                             "2018-10-24T18:27:15Z",
+				"dcterms:modified":                            "2018-10-24T18:27:15Z",
+				"description":                                 "Application for Automatic Extension of Time To File U.S. Individual Income Tax Return",
+				"language":                                    "en",
+				"meta:author":                                 "SE:W:CAR:MP",
+				"meta:creation-date":                          "2018-10-24T18:27:15Z",
+				"meta:keyword":                                "Fillable",
+				"meta:save-date":                              "2018-10-24T18:27:15Z",
+				"modified":                                    "2018-10-24T18:27:15Z",
+				"pdf:PDFExtensionVersion":                     "1.7 Adobe Extension Level 5",
+				"pdf:PDFVersion":                              "1.7",
+				"pdf:charsPerPage":                            []string{"5339", "5284", "6173", "4918"},
+				"pdf:docinfo:created":                         "2018-10-24T18:27:15Z",
+				"pdf:docinfo:creator":                         "SE:W:CAR:MP",
+				"pdf:docinfo:creator_tool":                    "Adobe LiveCycle Designer ES 9.0",
+				"pdf:docinfo:custom:Accessibility":            "structured; tagged",
+				"pdf:docinfo:custom:Form_fields":              "fillable",
+				"pdf:docinfo:keywords":                        "Fillable",
+				"pdf:docinfo:modified":                        "2018-10-24T18:27:15Z",
+				"pdf:docinfo:producer":                        "Adobe LiveCycle Designer ES 9.0",
+				"pdf:docinfo:subject":                         "Application for Automatic Extension of Time To File U.S. Individual Income Tax Return",
+				"pdf:docinfo:title":                           "2018 Form 4868",
+				"pdf:encrypted":                               "false",
+				"pdf:unmappedUnicodeCharsPerPage":             []string{"0", "0", "0", "0"},
+				"producer":                                    "Adobe LiveCycle Designer ES 9.0",
+				"subject":                                     "Application for Automatic Extension of Time To File U.S. Individual Income Tax Return",
+				"title":                                       "2018 Form 4868",
+				"xmp:CreatorTool":                             "Adobe LiveCycle Designer ES 9.0",
+				"xmpMM:DocumentID":                            "uuid:140c797f-30d2-4145-a45d-56b02215393e",
+				"xmpTPg:NPages":                               "4",
+			},
 		},
 	}, docModel)
 	//require.Implements(t, (*Interface)(nil), config, "should implement the config interface")
 }
+
+func TestDocumentProcessor_StoreDocument_PreservesUserFields(t *testing.T) {
+
+	//setup
+	indexAttempts := 0
+	var storedPayload model.Document
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/lodestone/_search":
+			//no previous versions stored under a different checksum
+			fmt.Fprint(w, `{"hits": {"hits": []}}`)
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `{"_index":"lodestone","_id":"checksum","_seq_no":%d,"_primary_term":1,"found":true,"_source":{
+				"lodestone": {"title": "My Tax Return", "tags": ["taxes", "important"], "bookmark": true, "generated_tags": ["taxes", "2018"]}
+			}}`, 10+indexAttempts)
+		case r.Method == http.MethodPut:
+			indexAttempts++
+			require.Equal(t, fmt.Sprintf("%d", 10+indexAttempts-1), r.URL.Query().Get("if_seq_no"))
+			require.Equal(t, "1", r.URL.Query().Get("if_primary_term"))
+			if indexAttempts == 1 {
+				//simulate a concurrent modification by the webapp
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"error":{"type":"version_conflict_engine_exception"},"status":409}`)
+				return
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&storedPayload))
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `{"result":"updated"}`)
+		}
+	}))
+	defer server.Close()
+
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		elasticsearchClient: es,
+		elasticsearchIndex:  "lodestone",
+		logger:              logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	err = proc.storeDocument(model.Document{
+		ID:      "checksum",
+		Content: "updated content",
+		Lodestone: model.DocLodestone{
+			ProcessorVersion: "1.0.0",
+			Tags:             []string{"taxes", "2018", "2019"},
+		},
+	})
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 2, indexAttempts)
+	require.Equal(t, "updated content", storedPayload.Content)
+	//the user removed the "2018" tag, only the new "2019" tag is added
+	require.Equal(t, model.DocLodestone{
+		ProcessorVersion: "1.0.0",
+		Title:            "My Tax Return",
+		Tags:             []string{"taxes", "important", "2019"},
+		Bookmark:         true,
+		GeneratedTags:    []string{"taxes", "2018", "2019"},
+	}, storedPayload.Lodestone)
+}
+
+func TestDocumentProcessor_DeleteDocument(t *testing.T) {
+
+	//setup
+	var query map[string]interface{}
+	proc, closeServer := newTestFolderProcessor(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/lodestone/_delete_by_query", r.URL.Path)
+		require.Equal(t, "true", r.URL.Query().Get("refresh"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		fmt.Fprint(w, `{"deleted": 3, "failures": []}`)
+	})
+	defer closeServer()
+
+	//test
+	deleted, err := proc.deleteDocument("documents", `taxes/"quoted" \ report.pdf`)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+	require.Equal(t, map[string]interface{}{"query": map[string]interface{}{"bool": map[string]interface{}{
+		"filter": []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"storage.bucket": "documents"}},
+			map[string]interface{}{"term": map[string]interface{}{"storage.path": `taxes/"quoted" \ report.pdf`}},
+		},
+	}}}, query)
+}
+
+func TestDocumentProcessor_DeleteFolder(t *testing.T) {
+
+	//setup
+	var query map[string]interface{}
+	proc, closeServer := newTestFolderProcessor(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		fmt.Fprint(w, `{"deleted": 2, "failures": [{"id": "checksum", "cause": {"type": "version_conflict_engine_exception"}}]}`)
+	})
+	defer closeServer()
+
+	//test
+	deleted, err := proc.deleteFolder("documents", "taxes/2019")
+
+	//assert
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1 documents could not be deleted")
+	require.Equal(t, 2, deleted)
+	require.Equal(t,
+		map[string]interface{}{"prefix": map[string]interface{}{"storage.path": "taxes/2019/"}},
+		query["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]interface{})[1],
+	)
+
+	_, err = proc.deleteFolder("documents", "")
+	require.Error(t, err)
+}
+
+// fakeDocumentIndex is a minimal in-memory elasticsearch index, supporting the requests used to store documents
+type fakeDocumentIndex struct {
+	seqNo  int
+	docs   map[string]map[string]interface{} //stored as json, including fields not modelled by model.Document
+	seqNos map[string]int
+}
+
+func (fi *fakeDocumentIndex) document(t *testing.T, id string) model.Document {
+	data, err := json.Marshal(fi.docs[id])
+	require.NoError(t, err)
+	var doc model.Document
+	require.NoError(t, json.Unmarshal(data, &doc))
+	return doc
+}
+
+func (fi *fakeDocumentIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := strings.TrimPrefix(r.URL.Path, "/lodestone/_doc/")
+	switch {
+	case r.URL.Path == "/lodestone/_search":
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter  []map[string]map[string]interface{} `json:"filter"`
+					MustNot []map[string]map[string]interface{} `json:"must_not"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		path := query.Query.Bool.Filter[1]["term"]["storage.path"]
+		excludedID := query.Query.Bool.MustNot[1]["ids"]["values"].([]interface{})[0]
+		hits := []map[string]interface{}{}
+		for docID, doc := range fi.docs {
+			if doc["storage"].(map[string]interface{})["path"] == path && doc["parent_id"] == nil && docID != excludedID {
+				hits = append(hits, map[string]interface{}{"_id": docID, "_seq_no": fi.seqNos[docID], "_primary_term": 1, "_source": doc})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+	case r.URL.Path == "/lodestone/_delete_by_query":
+		fmt.Fprint(w, `{"deleted": 0, "failures": []}`)
+	case r.URL.Path == "/_bulk":
+		fmt.Fprint(w, `{"errors": false, "items": []}`)
+	case r.Method == http.MethodGet:
+		doc, found := fi.docs[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"found": false}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"_id": id, "found": true, "_seq_no": fi.seqNos[id], "_primary_term": 1, "_source": doc})
+	case r.Method == http.MethodPut:
+		_, exists := fi.docs[id]
+		if (exists && r.URL.Query().Get("op_type") == "create") || (exists && r.URL.Query().Get("if_seq_no") != strconv.Itoa(fi.seqNos[id])) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		var doc map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fi.seqNo++
+		fi.docs[id], fi.seqNos[id] = doc, fi.seqNo
+		fmt.Fprint(w, `{"result": "created"}`)
+	case r.Method == http.MethodDelete:
+		if r.URL.Query().Get("if_seq_no") != strconv.Itoa(fi.seqNos[id]) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(fi.docs, id)
+		fmt.Fprint(w, `{"result": "deleted"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestDocumentProcessor_Process_ContentChanged(t *testing.T) {
+
+	//setup
+	fileContent := "Shopping list\n\nmilk, eggs"
+	storageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/storage/documents/notes/todo.txt", r.URL.Path)
+		w.Header().Set("X-Amz-Meta-Lodestone-Owner", "jason")
+		fmt.Fprint(w, fileContent)
+	}))
+	defer storageServer.Close()
+	storageUrl, err := url.Parse(storageServer.URL)
+	require.NoError(t, err)
+
+	index := &fakeDocumentIndex{docs: map[string]map[string]interface{}{}, seqNos: map[string]int{}}
+	proc, closeServer := newTestFolderProcessor(t, index.ServeHTTP)
+	defer closeServer()
+	proc.apiEndpoint = storageUrl
+	proc.filter = &model.Filter{}
+	proc.nativeExtraction = true
+	proc.metadataMapping, err = loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+
+	event := `{"Records": [{"eventName": "s3:ObjectCreated:Put", "s3": {"bucket": {"name": "documents"}, "object": {"key": "notes/todo.txt"}}}]}`
+
+	//test
+	require.NoError(t, proc.Process([]byte(event)))
+	require.Len(t, index.docs, 1)
+	for _, doc := range index.docs {
+		//the user curates the document in the webapp
+		lodestone := doc["lodestone"].(map[string]interface{})
+		lodestone["title"] = "Groceries"
+		lodestone["tags"] = []interface{}{"important"}
+		lodestone["bookmark"] = true
+		lodestone["notes"] = "buy on saturday" //not modelled by the processor
+	}
+
+	fileContent = "Shopping list\n\nmilk, eggs, bread"
+	require.NoError(t, proc.Process([]byte(event)))
+
+	//assert
+	require.Len(t, index.docs, 1, "the previous version of the document should be removed")
+	for id := range index.docs {
+		require.Equal(t, "buy on saturday", index.docs[id]["lodestone"].(map[string]interface{})["notes"], "unknown lodestone fields should be kept")
+		doc := index.document(t, id)
+		require.Equal(t, "Shopping list\n\nmilk, eggs, bread", doc.Content)
+		require.Equal(t, "Groceries", doc.Lodestone.Title)
+		require.Equal(t, []string{"important"}, doc.Lodestone.Tags, "tags removed by the user should not be added again")
+		require.True(t, doc.Lodestone.Bookmark)
+		require.Equal(t, "jason", doc.File.Owner)
+		require.Nil(t, doc.File.Created, "unknown dates are not indexed")
+	}
+}
@@ -0,0 +1,160 @@
+package document
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// number of bytes used to sniff the content type of a document
+const sniffContentTypeBytes = 512
+
+// documentSource is a document file that is written to disk and hashed while it is being downloaded. The first reader
+// streams the download directly (so Tika can extract the document while it is downloaded), subsequent readers (retries,
+// OCR) wait for the download to complete and read the local file.
+type documentSource struct {
+	localFilePath string
+
+	//first bytes of the file, used to sniff the content type
+	head []byte
+
+	//attributes of the original file, provided by the publisher
+	attributes model.FileAttributes
+
+	stream io.ReadCloser
+	done   chan struct{}
+
+	//only available once the download is complete, see wait()
+	err      error
+	checksum string
+	size     int64
+}
+
+// downloadDocumentSource starts writing body to localFilePath in the background, while hashing it and streaming it to
+// the first reader. body is closed once the download is complete.
+func downloadDocumentSource(body io.ReadCloser, localFilePath string) (*documentSource, error) {
+	bufferedBody := bufio.NewReaderSize(body, sniffContentTypeBytes)
+	head, err := bufferedBody.Peek(sniffContentTypeBytes)
+	if err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+
+	localFile, err := os.Create(localFilePath)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	streamReader, streamWriter := io.Pipe()
+	source := &documentSource{
+		localFilePath: localFilePath,
+		head:          append([]byte{}, head...),
+		stream:        streamReader,
+		done:          make(chan struct{}),
+	}
+
+	go func() {
+		defer close(source.done)
+		defer body.Close()
+		defer localFile.Close()
+
+		hasher := sha256.New()
+		stream := &detachableWriter{w: streamWriter}
+		source.size, source.err = io.Copy(io.MultiWriter(localFile, hasher, stream), bufferedBody)
+		if source.err == nil {
+			source.err = localFile.Sync()
+		}
+		source.checksum = hex.EncodeToString(hasher.Sum(nil))
+
+		//let the stream reader know the download is complete (or failed)
+		streamWriter.CloseWithError(source.err)
+	}()
+
+	return source, nil
+}
+
+// newLocalDocumentSource creates a documentSource for a file that is already stored on disk.
+func newLocalDocumentSource(localFilePath string) (*documentSource, error) {
+	localFile, err := os.Open(localFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer localFile.Close()
+
+	head := make([]byte, sniffContentTypeBytes)
+	headSize, err := io.ReadFull(localFile, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if _, err := localFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, localFile)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &documentSource{
+		localFilePath: localFilePath,
+		head:          head[:headSize],
+		done:          make(chan struct{}),
+		checksum:      hex.EncodeToString(hasher.Sum(nil)),
+		size:          size,
+	}
+	close(source.done)
+	return source, nil
+}
+
+// empty returns true if the downloaded file does not contain any data
+func (s *documentSource) empty() bool {
+	return len(s.head) == 0
+}
+
+// open returns a reader for the document. Only the first reader streams the download, it must be closed once it is
+// no longer used, so that the download can continue without it.
+func (s *documentSource) open() (io.ReadCloser, error) {
+	if s.stream != nil {
+		stream := s.stream
+		s.stream = nil
+		return stream, nil
+	}
+
+	if err := s.wait(); err != nil {
+		return nil, err
+	}
+	return os.Open(s.localFilePath)
+}
+
+// wait blocks until the download is complete, and returns the download error (if any). The stream is closed if it
+// was not opened yet, otherwise the download would block forever.
+func (s *documentSource) wait() error {
+	if s.stream != nil {
+		s.stream.Close()
+		s.stream = nil
+	}
+	<-s.done
+	return s.err
+}
+
+// detachableWriter stops writing to w after the first error (eg. when the stream reader was closed early), without
+// interrupting the other writers of an io.MultiWriter.
+type detachableWriter struct {
+	w        io.Writer
+	detached bool
+}
+
+func (dw *detachableWriter) Write(p []byte) (int, error) {
+	if !dw.detached {
+		if _, err := dw.w.Write(p); err != nil {
+			dw.detached = true
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,149 @@
+package document
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestDownloadDocumentSource(t *testing.T) {
+	//setup
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("lodestone ", 10000)
+
+	//test
+	source, err := downloadDocumentSource(ioutil.NopCloser(strings.NewReader(content)), filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+
+	stream, err := source.open()
+	require.NoError(t, err)
+	streamed, err := ioutil.ReadAll(stream)
+	require.NoError(t, err)
+	stream.Close()
+
+	//assert
+	require.NoError(t, source.wait())
+	require.Equal(t, content, string(streamed))
+	require.Equal(t, sha256Hex(content), source.checksum)
+	require.Equal(t, int64(len(content)), source.size)
+	require.Equal(t, []byte(content[:sniffContentTypeBytes]), source.head)
+	require.False(t, source.empty())
+
+	stored, err := ioutil.ReadFile(filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+	require.Equal(t, content, string(stored))
+
+	//subsequent readers read the local file
+	reopened, err := source.open()
+	require.NoError(t, err)
+	defer reopened.Close()
+	reread, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	require.Equal(t, content, string(reread))
+}
+
+func TestDownloadDocumentSource_StreamClosedEarly(t *testing.T) {
+	//setup
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("lodestone ", 10000)
+	source, err := downloadDocumentSource(ioutil.NopCloser(strings.NewReader(content)), filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+
+	//test
+	stream, err := source.open()
+	require.NoError(t, err)
+	_, err = io.ReadFull(stream, make([]byte, 10))
+	require.NoError(t, err)
+	stream.Close()
+
+	//assert
+	require.NoError(t, source.wait())
+	require.Equal(t, sha256Hex(content), source.checksum)
+	require.Equal(t, int64(len(content)), source.size)
+}
+
+func TestDownloadDocumentSource_Empty(t *testing.T) {
+	//setup
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	//test
+	source, err := downloadDocumentSource(ioutil.NopCloser(strings.NewReader("")), filepath.Join(dir, "empty.txt"))
+
+	//assert
+	require.NoError(t, err)
+	require.True(t, source.empty())
+	require.NoError(t, source.wait(), "the unused stream must not block the download")
+	require.Equal(t, int64(0), source.size)
+}
+
+func TestDocumentProcessor_ParseDocument_StreamsDownload(t *testing.T) {
+	//setup
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 1000)
+	tikaRequests := 0
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tikaRequests++
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, content, string(body))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"Content-Type": "text/plain; charset=UTF-8", "X-TIKA:content": "the quick brown fox"}]`)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	//the download is still in progress when the document is sent to tika
+	downloadReader, downloadWriter := io.Pipe()
+	go func() {
+		for _, line := range strings.SplitAfter(content, "\n") {
+			downloadWriter.Write([]byte(line))
+		}
+		downloadWriter.Close()
+	}()
+	source, err := downloadDocumentSource(downloadReader, filepath.Join(dir, "fox.txt"))
+	require.NoError(t, err)
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:            newTestTikaPool(t, tikaServer.URL),
+		metadataMapping: metadataMapping,
+		logger:          logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	docs, err := proc.parseDocument("documents", "notes/fox.txt", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 1, tikaRequests)
+	require.Equal(t, sha256Hex(content), docs[0].ID)
+	require.Equal(t, sha256Hex(content), docs[0].File.Checksum)
+	require.Equal(t, int64(len(content)), docs[0].File.Filesize)
+	require.Equal(t, "fox.txt", docs[0].File.FileName)
+}
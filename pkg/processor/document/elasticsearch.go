@@ -0,0 +1,298 @@
+package document
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+)
+
+// ElasticsearchConfig contains the connection settings for the elasticsearch cluster, as well as the index settings.
+type ElasticsearchConfig struct {
+	Addresses       []string //list of cluster nodes, ignored when CloudID is set
+	Index           string   //alias used to store documents
+	FolderIndex     string   //index used to store folders, defaults to "<Index>-folders"
+	MappingOverride string   //path to a file overriding static/document-processor/settings.json
+
+	Username string
+	Password string
+	APIKey   string //base64 encoded "id:api_key", overrides username & password
+	CloudID  string
+
+	CACertPath         string //PEM encoded CA certificate(s) used to verify the cluster certificate
+	InsecureSkipVerify bool
+
+	RetryOnStatus []int //HTTP status codes which should be retried (connection errors are always retried)
+	MaxRetries    int
+	DiscoverNodes bool //replace Addresses with the nodes discovered via the cluster Nodes Info API on startup
+}
+
+// Validate returns an error describing the first invalid setting.
+func (cfg ElasticsearchConfig) Validate() error {
+	if cfg.Index == "" {
+		return errors.New("elasticsearch: an index name is required")
+	}
+
+	if cfg.CloudID == "" {
+		if len(cfg.Addresses) == 0 {
+			return errors.New("elasticsearch: at least one endpoint (or a cloud id) is required")
+		}
+		for _, address := range cfg.Addresses {
+			addressUrl, err := url.Parse(address)
+			if err != nil {
+				return fmt.Errorf("elasticsearch: invalid endpoint %q: %v", address, err)
+			}
+			if (addressUrl.Scheme != "http" && addressUrl.Scheme != "https") || addressUrl.Host == "" {
+				return fmt.Errorf("elasticsearch: invalid endpoint %q, must be an absolute http(s) url", address)
+			}
+		}
+	} else if cfg.DiscoverNodes {
+		return errors.New("elasticsearch: node discovery is not supported when connecting using a cloud id")
+	}
+
+	if cfg.APIKey != "" && (cfg.Username != "" || cfg.Password != "") {
+		return errors.New("elasticsearch: specify either an api key or a username & password, not both")
+	}
+	if (cfg.Username == "") != (cfg.Password == "") {
+		return errors.New("elasticsearch: both a username and password are required for basic authentication")
+	}
+
+	if cfg.CACertPath != "" {
+		if _, err := cfg.certPool(); err != nil {
+			return err
+		}
+	}
+
+	for _, status := range cfg.RetryOnStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("elasticsearch: invalid retry status code %d", status)
+		}
+	}
+	if cfg.MaxRetries < 0 {
+		return errors.New("elasticsearch: max retries cannot be negative")
+	}
+	if cfg.folderIndex() == cfg.Index {
+		return errors.New("elasticsearch: the folder index must differ from the document index")
+	}
+	return nil
+}
+
+func (cfg ElasticsearchConfig) folderIndex() string {
+	if cfg.FolderIndex == "" {
+		return cfg.Index + "-folders"
+	}
+	return cfg.FolderIndex
+}
+
+func (cfg ElasticsearchConfig) certPool() (*x509.CertPool, error) {
+	caCert, err := ioutil.ReadFile(cfg.CACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch: could not read CA certificate: %v", err)
+	}
+
+	certPool, err := x509.SystemCertPool()
+	if err != nil || certPool == nil {
+		certPool = x509.NewCertPool()
+	}
+	if !certPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("elasticsearch: no PEM encoded certificates found in %s", cfg.CACertPath)
+	}
+	return certPool, nil
+}
+
+func (cfg ElasticsearchConfig) httpTransport() (http.RoundTripper, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+		},
+	}
+
+	if cfg.CACertPath != "" {
+		certPool, err := cfg.certPool()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = certPool
+	}
+	return transport, nil
+}
+
+// newElasticsearchClient creates a client using the (validated) configuration. When node discovery is enabled, the
+// cluster is queried for its http enabled nodes, and a new client is created using their addresses.
+func newElasticsearchClient(cfg ElasticsearchConfig) (*elasticsearch.Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	transport, err := cfg.httpTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	esCfg := elasticsearch.Config{
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		APIKey:    cfg.APIKey,
+		CloudID:   cfg.CloudID,
+		Transport: transport,
+	}
+	if cfg.CloudID == "" {
+		esCfg.Addresses = cfg.Addresses
+	}
+
+	es, err := elasticsearch.NewClient(esCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	//the API methods are bound to the transport when the client is created, so create a new client using the wrapped transport.
+	retryingTransport := &retryTransport{
+		transport:     es.Transport,
+		retryOnStatus: cfg.RetryOnStatus,
+		maxRetries:    cfg.MaxRetries,
+		backoff:       500 * time.Millisecond,
+	}
+	es = &elasticsearch.Client{Transport: retryingTransport, API: esapi.New(retryingTransport)}
+
+	if !cfg.DiscoverNodes {
+		return es, nil
+	}
+
+	discoveredAddresses, err := discoverNodes(es, cfg.Addresses[0])
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch: node discovery failed: %v", err)
+	}
+	if len(discoveredAddresses) == 0 {
+		return es, nil
+	}
+
+	cfg.Addresses = discoveredAddresses
+	cfg.DiscoverNodes = false
+	return newElasticsearchClient(cfg)
+}
+
+// discoverNodes returns the http publish address of every node in the cluster. The scheme of the seed address is used
+// for all nodes.
+func discoverNodes(es *elasticsearch.Client, seedAddress string) ([]string, error) {
+	seedUrl, err := url.Parse(seedAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := es.Nodes.Info(es.Nodes.Info.WithMetric("http"), es.Nodes.Info.WithFilterPath("nodes.*.http.publish_address"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return nil, fmt.Errorf("%s", resp.String())
+	}
+
+	var nodesInfo struct {
+		Nodes map[string]struct {
+			Http struct {
+				PublishAddress string `json:"publish_address"`
+			} `json:"http"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&nodesInfo); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, node := range nodesInfo.Nodes {
+		publishAddress := node.Http.PublishAddress
+		if publishAddress == "" {
+			continue
+		}
+		//publish addresses may be formatted as "hostname/ip:port"
+		if parts := strings.SplitN(publishAddress, "/", 2); len(parts) == 2 {
+			publishAddress = parts[1]
+		}
+		addresses = append(addresses, fmt.Sprintf("%s://%s", seedUrl.Scheme, publishAddress))
+	}
+	return addresses, nil
+}
+
+// retryTransport retries requests which failed with a connection error or a retryable status code. The wrapped
+// transport selects the next node (round robin) for every attempt, so retries are spread across the cluster.
+type retryTransport struct {
+	transport     estransport.Interface
+	retryOnStatus []int
+	maxRetries    int
+	backoff       time.Duration
+}
+
+func (t *retryTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	originalUrl := *req.URL
+
+	for attempt := 0; ; attempt++ {
+		//the underlying transport modifies the request url, so every attempt needs a fresh copy of the request.
+		attemptReq := req.WithContext(req.Context())
+		attemptUrl := originalUrl
+		attemptReq.URL = &attemptUrl
+		attemptReq.Header = req.Header.Clone()
+		if body != nil {
+			attemptReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+			attemptReq.ContentLength = int64(len(body))
+		}
+
+		resp, err := t.transport.Perform(attemptReq)
+		if attempt >= t.maxRetries || !t.shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(t.backoff * time.Duration(attempt+1)):
+		}
+	}
+}
+
+func (t *retryTransport) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	for _, status := range t.retryOnStatus {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package document
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestElasticsearchConfig_Validate(t *testing.T) {
+	caCertFile, err := ioutil.TempFile("", "ca*.pem")
+	require.NoError(t, err)
+	caCertFile.WriteString("not a certificate")
+	caCertFile.Close()
+	defer os.Remove(caCertFile.Name())
+
+	valid := ElasticsearchConfig{Addresses: []string{"http://elasticsearch:9200"}, Index: "lodestone", RetryOnStatus: []int{502, 503}, MaxRetries: 3}
+
+	var tests = []struct {
+		modify func(cfg *ElasticsearchConfig)
+		err    string
+	}{
+		{func(cfg *ElasticsearchConfig) {}, ""},
+		{func(cfg *ElasticsearchConfig) { cfg.Addresses = append(cfg.Addresses, "https://es2:9200") }, ""},
+		{func(cfg *ElasticsearchConfig) { cfg.Username, cfg.Password = "elastic", "changeme" }, ""},
+		{func(cfg *ElasticsearchConfig) { cfg.Addresses, cfg.CloudID = nil, "name:ZXhhbXBsZS5jb20kYWJjJGRlZg==" }, ""},
+		{func(cfg *ElasticsearchConfig) { cfg.Index = "" }, "an index name is required"},
+		{func(cfg *ElasticsearchConfig) { cfg.Addresses = nil }, "at least one endpoint"},
+		{func(cfg *ElasticsearchConfig) { cfg.Addresses = []string{"elasticsearch:9200"} }, "must be an absolute http(s) url"},
+		{func(cfg *ElasticsearchConfig) { cfg.CloudID, cfg.DiscoverNodes = "name:abc", true }, "node discovery is not supported"},
+		{func(cfg *ElasticsearchConfig) { cfg.Username, cfg.APIKey = "elastic", "key" }, "either an api key or a username"},
+		{func(cfg *ElasticsearchConfig) { cfg.Username = "elastic" }, "both a username and password are required"},
+		{func(cfg *ElasticsearchConfig) { cfg.CACertPath = "/does/not/exist.pem" }, "could not read CA certificate"},
+		{func(cfg *ElasticsearchConfig) { cfg.CACertPath = caCertFile.Name() }, "no PEM encoded certificates found"},
+		{func(cfg *ElasticsearchConfig) { cfg.RetryOnStatus = []int{5003} }, "invalid retry status code 5003"},
+		{func(cfg *ElasticsearchConfig) { cfg.MaxRetries = -1 }, "max retries cannot be negative"},
+		{func(cfg *ElasticsearchConfig) { cfg.FolderIndex = "lodestone" }, "folder index must differ"},
+	}
+
+	for i, tt := range tests {
+		cfg := valid
+		tt.modify(&cfg)
+		err := cfg.Validate()
+		if tt.err == "" {
+			require.NoError(t, err, "test %d", i)
+		} else {
+			require.Error(t, err, "test %d", i)
+			require.Contains(t, err.Error(), tt.err, "test %d", i)
+		}
+	}
+}
+
+func TestNewElasticsearchClient_RetryAcrossNodes(t *testing.T) {
+
+	//setup
+	unavailableRequests := 0
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unavailableRequests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	var receivedBody string
+	available := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/lodestone/_doc/1", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		receivedBody = string(body)
+		fmt.Fprint(w, `{"result":"created"}`)
+	}))
+	defer available.Close()
+
+	es, err := newElasticsearchClient(ElasticsearchConfig{
+		Addresses:     []string{unavailable.URL, available.URL},
+		Index:         "lodestone",
+		RetryOnStatus: []int{http.StatusServiceUnavailable},
+		MaxRetries:    1,
+	})
+	require.NoError(t, err)
+	es.Transport.(*retryTransport).backoff = 0
+
+	//test
+	resp, err := es.Index("lodestone", strings.NewReader(`{"content":"retried"}`), es.Index.WithDocumentID("1"))
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 1, unavailableRequests)
+	require.Equal(t, `{"content":"retried"}`, receivedBody)
+}
+
+func TestNewElasticsearchClient_DiscoverNodes(t *testing.T) {
+
+	//setup
+	discovered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	defer discovered.Close()
+
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_nodes/http", r.URL.Path)
+		fmt.Fprintf(w, `{"nodes": {"node1": {"http": {"publish_address": "es1/%s"}}}}`, strings.TrimPrefix(discovered.URL, "http://"))
+	}))
+	defer seed.Close()
+
+	//test
+	es, err := newElasticsearchClient(ElasticsearchConfig{
+		Addresses:     []string{seed.URL},
+		Index:         "lodestone",
+		DiscoverNodes: true,
+	})
+
+	//assert
+	require.NoError(t, err)
+	urls := es.Transport.(*retryTransport).transport.(interface{ URLs() []*url.URL }).URLs()
+	require.Len(t, urls, 1)
+	require.Equal(t, discovered.URL, urls[0].String())
+}
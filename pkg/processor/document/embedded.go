@@ -0,0 +1,141 @@
+package document
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/google/go-tika/tika"
+)
+
+// Tika recursive metadata keys (see https://cwiki.apache.org/confluence/display/TIKA/TikaServer#TikaServer-RecursiveMetadataandContent)
+const (
+	tikaEmbeddedResourcePath = "X-TIKA:embedded_resource_path"
+	tikaEmbeddedDepth        = "X-TIKA:embedded_depth"
+	tikaResourceName         = "resourceName"
+	tikaDigestSHA256         = "X-TIKA:digest:SHA256"
+)
+
+// separates the path of the container document from the path of the embedded resource, eg. "archive.zip!/inner/report.pdf"
+const embeddedPathSeparator = "!"
+
+// resourceContent returns the text content (and pages) of a resource returned by the recursive metadata endpoint. The
+// content is requested as XHTML, so that page boundaries are available.
+func resourceContent(resource map[string][]string) (string, []model.DocPage) {
+	return parseXhtmlContent(strings.Join(resource[tika.XTIKAContent], "\n"))
+}
+
+// resourceMetadata returns the metadata of a resource returned by the recursive metadata endpoint, without its content,
+// in the same format as the metadata returned by the /meta endpoint.
+func resourceMetadata(resource map[string][]string) map[string]interface{} {
+	parsedMeta := map[string]interface{}{}
+	for key, values := range resource {
+		if key == tika.XTIKAContent {
+			continue
+		}
+		if len(values) == 1 {
+			parsedMeta[key] = values[0]
+		} else {
+			parsedMeta[key] = values
+		}
+	}
+	return parsedMeta
+}
+
+// embeddedDocument creates a child document for a resource embedded in the parent document (archive members, email
+// attachments, objects embedded in office documents, etc). The child is stored with the same storage bucket & path as
+// its parent, so deleting the parent file also deletes its children.
+func (dp *DocumentProcessor) embeddedDocument(parent model.Document, resource map[string][]string) (model.Document, error) {
+	parsedMeta := resourceMetadata(resource)
+	embeddedPath := castToString(parsedMeta[tikaEmbeddedResourcePath])
+	if !strings.HasPrefix(embeddedPath, "/") {
+		embeddedPath = "/" + embeddedPath
+	}
+
+	fileName := castToString(parsedMeta[tikaResourceName])
+	if fileName == "" {
+		fileName = path.Base(embeddedPath)
+	}
+
+	//embedded resources do not have their own file checksum, derive a stable ID from the parent checksum and the path.
+	idHash := sha256.Sum256([]byte(parent.File.Checksum + embeddedPathSeparator + embeddedPath))
+	depth, _ := strconv.Atoi(castToString(parsedMeta[tikaEmbeddedDepth]))
+	content, pages := resourceContent(resource)
+	fileSize, _ := castToInteger(parsedMeta["Content-Length"])
+
+	child := model.Document{
+		ID:       hex.EncodeToString(idHash[:]),
+		Content:  content,
+		Pages:    pages,
+		ParentID: parent.ID,
+		Lodestone: model.DocLodestone{
+			ProcessorVersion: parent.Lodestone.ProcessorVersion,
+			Tags:             parent.Lodestone.Tags,
+		},
+		File: model.DocFile{
+			FileName:     fileName,
+			Extension:    strings.ToLower(strings.TrimPrefix(path.Ext(fileName), ".")),
+			Filesize:     fileSize,
+			IndexedChars: int64(len(content)),
+			TextQuality:  textQuality(content),
+			IndexedDate:  time.Now(),
+			LastModified: parent.File.LastModified,
+			Checksum:     castToString(parsedMeta[tikaDigestSHA256]),
+		},
+		Storage: model.DocStorage{
+			Bucket:        parent.Storage.Bucket,
+			Path:          parent.Storage.Path,
+			Folder:        parent.Storage.Folder,
+			FolderTree:    parent.Storage.FolderTree,
+			VirtualPath:   parent.Storage.Path + embeddedPathSeparator + embeddedPath,
+			EmbeddedPath:  embeddedPath,
+			EmbeddedDepth: depth,
+		},
+	}
+
+	err := dp.applyTikaMetadata(parsedMeta, &child)
+	if child.Meta.Pages == 0 {
+		child.Meta.Pages = len(pages)
+	}
+	if !child.Meta.SavedDate.IsZero() {
+		child.File.LastModified = optionalTime(child.Meta.SavedDate)
+	}
+	return child, err
+}
+
+// deleteStaleChildDocuments removes the embedded documents & content chunks of a previous version of the parent file.
+// Child document IDs are derived from the parent checksum, so they change whenever the parent file changes. docs are
+// the documents that were just stored for the file, starting with the parent.
+func (dp *DocumentProcessor) deleteStaleChildDocuments(docs []model.Document) error {
+	parent := docs[0]
+	currentIds := []string{}
+	for _, doc := range docs {
+		currentIds = append(currentIds, doc.ID)
+	}
+
+	dp.logger.Debugln("Attempting to delete stale child documents in elasticsearch")
+	deleted, err := dp.deleteByQuery(dp.elasticsearchIndex, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"storage.bucket": parent.Storage.Bucket}},
+				map[string]interface{}{"term": map[string]interface{}{"storage.path": parent.Storage.Path}},
+				map[string]interface{}{"exists": map[string]interface{}{"field": "parent_id"}},
+			},
+			"must_not": []interface{}{
+				map[string]interface{}{"ids": map[string]interface{}{"values": currentIds}},
+			},
+		},
+	}, false)
+	if err != nil {
+		dp.logger.Printf("An error occurred while deleting stale child documents: %v", err)
+		return err
+	}
+	if deleted > 0 {
+		dp.logger.Debugf("Deleted %d stale child documents", deleted)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package document
+
+import (
+	"fmt"
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDocumentProcessor_ParseDocument_Embedded(t *testing.T) {
+
+	//setup
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/rmeta/xml", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Accept"))
+		require.Equal(t, "deu", r.Header.Get("X-Tika-OCRLanguage"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"Content-Type": "application/zip", "X-TIKA:content": "\ninner/report.pdf\nnotes.txt\n", "X-Parsed-By": ["org.apache.tika.parser.DefaultParser", "org.apache.tika.parser.pkg.PackageParser"]},
+			{"Content-Type": "application/pdf", "X-TIKA:content": "<html xmlns=\"http://www.w3.org/1999/xhtml\"><head><title>Q3</title></head><body><div class=\"page\"><p>Quarterly Report</p></div><div class=\"page\"><p>Revenue</p></div></body></html>", "X-TIKA:embedded_resource_path": "/inner/report.pdf", "X-TIKA:embedded_depth": "1", "resourceName": "report.pdf", "dc:title": "Q3 Report", "Last-Save-Date": "2019-10-01T12:00:00Z"},
+			{"Content-Type": "text/plain; charset=UTF-8", "X-TIKA:content": "remember the milk", "X-TIKA:embedded_resource_path": "/notes.txt", "X-TIKA:embedded_depth": "1", "Content-Length": "17"}
+		]`)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "archive.zip")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("PK not really a zip"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:                   newTestTikaPool(t, tikaServer.URL),
+		ocrLanguageOverride:    "deu",
+		storageThumbnailBucket: "thumbnails",
+		metadataMapping:        metadataMapping,
+		logger:                 logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "backups/archive.zip", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, docs, 3)
+
+	parent := docs[0]
+	require.Equal(t, "inner/report.pdf\nnotes.txt", parent.Content)
+	require.Equal(t, "application/zip", parent.File.ContentType)
+	require.Equal(t, "", parent.ParentID)
+	require.Equal(t, "", parent.Storage.VirtualPath)
+	require.Nil(t, parent.Pages)
+	require.Equal(t, "backups/archive.zip.jpg", parent.Storage.ThumbPath)
+
+	report := docs[1]
+	require.Equal(t, parent.ID, report.ParentID)
+	require.NotEqual(t, parent.ID, report.ID)
+	require.Equal(t, "Quarterly Report\n\nRevenue", report.Content)
+	require.Equal(t, []model.DocPage{{Number: 1, Content: "Quarterly Report"}, {Number: 2, Content: "Revenue"}}, report.Pages)
+	require.Equal(t, 2, report.Meta.Pages)
+	require.Equal(t, "report.pdf", report.File.FileName)
+	require.Equal(t, "pdf", report.File.Extension)
+	require.Equal(t, "application/pdf", report.File.ContentType)
+	require.Equal(t, "Q3 Report", report.Meta.Title)
+	require.Equal(t, "2019-10-01T12:00:00Z", report.File.LastModified.Format("2006-01-02T15:04:05Z07:00"))
+	require.Equal(t, []string{"backups"}, report.Lodestone.Tags)
+	require.Equal(t, "documents", report.Storage.Bucket)
+	require.Equal(t, "backups/archive.zip", report.Storage.Path)
+	require.Equal(t, "backups", report.Storage.Folder)
+	require.Equal(t, []string{"backups"}, report.Storage.FolderTree)
+	require.Equal(t, "backups/archive.zip!/inner/report.pdf", report.Storage.VirtualPath)
+	require.Equal(t, "/inner/report.pdf", report.Storage.EmbeddedPath)
+	require.Equal(t, 1, report.Storage.EmbeddedDepth)
+	require.Equal(t, "", report.Storage.ThumbPath)
+
+	notes := docs[2]
+	require.Equal(t, "notes.txt", notes.File.FileName)
+	require.Equal(t, int64(17), notes.File.Filesize)
+	require.Equal(t, "backups/archive.zip!/notes.txt", notes.Storage.VirtualPath)
+
+	//ids must be stable across runs
+	source, err = newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docsAgain, err := proc.parseDocument("documents", "backups/archive.zip", source)
+	require.NoError(t, err)
+	require.Equal(t, report.ID, docsAgain[1].ID)
+}
@@ -0,0 +1,302 @@
+package document
+
+import (
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// maximum number of entities of each type stored per document
+const maxEntitiesPerType = 100
+
+// EntityConfig enables the extraction of structured entities from the document content. Entities are stored in the
+// `entities` section of the document, with typed mappings (eg. `entities.amounts` is a nested value & currency field),
+// so documents can be filtered with range queries, eg. all invoices with an amount over 500 USD.
+type EntityConfig struct {
+	Dates    bool //eg. "2019-04-15", "04/15/2019", "15.04.2019", "April 15, 2019"
+	Amounts  bool //amounts with a currency symbol or code, eg. "$1,234.56", "1.234,56 EUR"
+	Emails   bool
+	Phones   bool //international numbers ("+44 20 7946 0958"), "(555) 123-4567" and labelled numbers ("Fax: 555-123-4567")
+	URLs     bool
+	Accounts bool //IBANs (checksum validated) and labelled account numbers, eg. "Account No: 1234-5678"
+}
+
+var (
+	//full month names and their standard abbreviations, so words like "novels" or "decades" are not mistaken for months
+	entityMonths = `(january|jan|february|feb|march|mar|april|apr|may|june|jun|july|jul|august|aug|september|sept|sep|october|oct|november|nov|december|dec)\.?`
+
+	isoDatePattern          = regexp.MustCompile(`\b((?:19|20)\d{2})[-/](\d{1,2})[-/](\d{1,2})\b`)
+	numericDatePattern      = regexp.MustCompile(`\b(\d{1,2})([./-])(\d{1,2})([./-])((?:19|20)\d{2})\b`)
+	dayMonthYearDatePattern = regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th)?\.?\s+` + entityMonths + `,?\s+((?:19|20)\d{2})\b`)
+	monthDayYearDatePattern = regexp.MustCompile(`(?i)\b` + entityMonths + `\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+((?:19|20)\d{2})\b`)
+
+	entityCurrencySymbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY", "₹": "INR"}
+	entityCurrencies      = `[$€£¥₹]|\b(?:USD|EUR|GBP|CHF|CAD|AUD|NZD|JPY|CNY|INR|SEK|NOK|DKK|PLN|CZK|MXN|BRL|ZAR)\b`
+	entityAmount          = `\d{1,3}(?:[,.\x{00A0}\x{202F}]\d{3})+(?:[.,]\d{1,2})?|\d+(?:[.,]\d{1,2})?`
+	amountPattern         = regexp.MustCompile(`(?:(` + entityCurrencies + `)\s?(` + entityAmount + `))|(?:(` + entityAmount + `)\s?(` + entityCurrencies + `))`)
+
+	emailPattern = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}\b`)
+
+	// numbers like "555-123-4567" are often invoice, order or account numbers, so they are only phone numbers when they
+	// follow a label. International numbers and numbers with an area code in parentheses are recognized without one.
+	entityPhoneNumber = `\B\+\d{1,3}(?:[\s.-]?\(?\d{1,4}\)?)(?:[\s.-]?\d{2,5}){1,5}|\B\(\d{3}\)\s?\d{3}[\s.-]\d{4}\b`
+	phonePattern      = regexp.MustCompile(`(?i)\b(?:tel|telephone|phone|ph|fax|mobile|mob|cell|call)\b\.?(?:\s*(?:no|nr|number|#)\.?)?\s*[:#]?\s*(` + entityPhoneNumber + `|\d{3}[\s.-]?\d{3}[\s.-]\d{4}\b)|` + entityPhoneNumber)
+	urlPattern        = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'` + "`" + `]+`)
+
+	ibanPattern          = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`)
+	accountNumberPattern = regexp.MustCompile(`(?i)\b(?:account|acct|a/c)\.?(?:\s*(?:no|nr|num|number|#)\.?)?\s*[:#]?\s*(\d[\d -]{4,22}\d)\b`)
+)
+
+// extractEntities extracts the enabled entity types from the document content.
+func (dp *DocumentProcessor) extractEntities(doc *model.Document) {
+	config := dp.entityConfig
+	content := doc.Content
+	if content == "" {
+		return
+	}
+
+	if config.Dates {
+		doc.Entities.Dates = extractDates(content)
+	}
+	if config.Amounts {
+		doc.Entities.Amounts = extractAmounts(content)
+	}
+	if config.Emails {
+		doc.Entities.Emails = extractEntityStrings(emailPattern, content, func(match string) string {
+			return strings.ToLower(match)
+		})
+	}
+	if config.Phones {
+		doc.Entities.Phones = extractPhoneNumbers(content)
+	}
+	if config.URLs {
+		doc.Entities.URLs = extractEntityStrings(urlPattern, content, func(match string) string {
+			return strings.TrimRight(match, `.,;:!?)]}'"`)
+		})
+	}
+	if config.Accounts {
+		doc.Entities.Ibans = extractEntityStrings(ibanPattern, content, normalizeIban)
+		doc.Entities.AccountNumbers = extractAccountNumbers(content)
+	}
+}
+
+// extractEntityStrings returns the unique (normalized) matches of pattern. normalize returns "" for invalid matches.
+func extractEntityStrings(pattern *regexp.Regexp, content string, normalize func(match string) string) []string {
+	var entities []string
+	for _, match := range pattern.FindAllString(content, -1) {
+		if len(entities) >= maxEntitiesPerType {
+			break
+		}
+		if entity := normalize(match); entity != "" && !containsString(entities, entity) {
+			entities = append(entities, entity)
+		}
+	}
+	return entities
+}
+
+// extractDates returns the unique, valid dates in the content, in order of appearance. Dotted dates (15.04.2019) are
+// always day.month.year. Other numeric dates are ambiguous (04/05/2019 is April 5th in the US, and May 4th in most other
+// countries), they are only extracted when one of the numbers cannot be a month.
+func extractDates(content string) []time.Time {
+	type dateMatch struct {
+		position int
+		date     time.Time
+	}
+	matches := []dateMatch{}
+	addDate := func(position int, year string, month int, day string) {
+		yearValue, _ := strconv.Atoi(year)
+		dayValue, _ := strconv.Atoi(day)
+		date := time.Date(yearValue, time.Month(month), dayValue, 0, 0, 0, 0, time.UTC)
+		//time.Date normalizes invalid dates (eg. February 30th), reject them instead
+		if month >= 1 && month <= 12 && date.Day() == dayValue && int(date.Month()) == month {
+			matches = append(matches, dateMatch{position, date})
+		}
+	}
+
+	for _, match := range isoDatePattern.FindAllStringSubmatchIndex(content, -1) {
+		month, _ := strconv.Atoi(content[match[4]:match[5]])
+		addDate(match[0], content[match[2]:match[3]], month, content[match[6]:match[7]])
+	}
+	for _, match := range numericDatePattern.FindAllStringSubmatchIndex(content, -1) {
+		if content[match[4]:match[5]] != content[match[8]:match[9]] {
+			//mixed separators, eg. a version number
+			continue
+		}
+		first, _ := strconv.Atoi(content[match[2]:match[3]])
+		second, _ := strconv.Atoi(content[match[6]:match[7]])
+		year := content[match[10]:match[11]]
+		if content[match[4]:match[5]] == "." || first > 12 {
+			addDate(match[0], year, second, content[match[2]:match[3]])
+		} else if second > 12 || first == second {
+			addDate(match[0], year, first, content[match[6]:match[7]])
+		}
+	}
+	for _, match := range dayMonthYearDatePattern.FindAllStringSubmatchIndex(content, -1) {
+		addDate(match[0], content[match[6]:match[7]], monthNumber(content[match[4]:match[5]]), content[match[2]:match[3]])
+	}
+	for _, match := range monthDayYearDatePattern.FindAllStringSubmatchIndex(content, -1) {
+		addDate(match[0], content[match[6]:match[7]], monthNumber(content[match[2]:match[3]]), content[match[4]:match[5]])
+	}
+
+	//order by appearance
+	for i := 1; i < len(matches); i++ {
+		for j := i; j > 0 && matches[j].position < matches[j-1].position; j-- {
+			matches[j], matches[j-1] = matches[j-1], matches[j]
+		}
+	}
+
+	var dates []time.Time
+	for _, match := range matches {
+		if len(dates) >= maxEntitiesPerType {
+			break
+		}
+		duplicate := false
+		for _, date := range dates {
+			if date.Equal(match.date) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			dates = append(dates, match.date)
+		}
+	}
+	return dates
+}
+
+func monthNumber(month string) int {
+	return strings.Index("janfebmaraprmayjunjulaugsepoctnovdec", strings.ToLower(month)[:3])/3 + 1
+}
+
+// extractAmounts returns the unique amounts (with a currency symbol or code) in the content, in order of appearance.
+func extractAmounts(content string) []model.DocAmount {
+	var amounts []model.DocAmount
+	for _, match := range amountPattern.FindAllStringSubmatch(content, -1) {
+		if len(amounts) >= maxEntitiesPerType {
+			break
+		}
+
+		currency, number := match[1], match[2]
+		if currency == "" {
+			currency, number = match[4], match[3]
+		}
+		if code, ok := entityCurrencySymbols[currency]; ok {
+			currency = code
+		}
+		value, ok := parseAmount(number)
+		if !ok {
+			continue
+		}
+
+		amount := model.DocAmount{Value: value, Currency: currency}
+		duplicate := false
+		for _, existing := range amounts {
+			if existing == amount {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			amounts = append(amounts, amount)
+		}
+	}
+	return amounts
+}
+
+// parseAmount parses numbers using either "," or "." as decimal separator. The last separator is the decimal separator
+// when it is followed by 1 or 2 digits, otherwise every separator is a thousands separator.
+func parseAmount(number string) (float64, bool) {
+	number = strings.NewReplacer("\u00a0", "", "\u202f", "").Replace(number)
+	decimals := ""
+	if separator := strings.LastIndexAny(number, ".,"); separator >= 0 && len(number)-separator-1 <= 2 {
+		decimals = number[separator+1:]
+		number = number[:separator]
+	}
+	number = strings.NewReplacer(".", "", ",", "").Replace(number)
+	if decimals != "" {
+		number = number + "." + decimals
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	return value, err == nil
+}
+
+// extractPhoneNumbers returns the unique phone numbers in the content (see phonePattern), in order of appearance.
+func extractPhoneNumbers(content string) []string {
+	var phoneNumbers []string
+	for _, match := range phonePattern.FindAllStringSubmatch(content, -1) {
+		if len(phoneNumbers) >= maxEntitiesPerType {
+			break
+		}
+		number := match[0]
+		if match[1] != "" {
+			//without the label
+			number = match[1]
+		}
+		if phoneNumber := normalizePhoneNumber(number); phoneNumber != "" && !containsString(phoneNumbers, phoneNumber) {
+			phoneNumbers = append(phoneNumbers, phoneNumber)
+		}
+	}
+	return phoneNumbers
+}
+
+// normalizePhoneNumber removes formatting characters (keeping a leading "+"), and rejects numbers with an invalid
+// number of digits.
+func normalizePhoneNumber(match string) string {
+	var normalized strings.Builder
+	digits := 0
+	for i, char := range match {
+		if char >= '0' && char <= '9' {
+			normalized.WriteRune(char)
+			digits++
+		} else if char == '+' && i == 0 {
+			normalized.WriteRune(char)
+		}
+	}
+	if digits < 7 || digits > 15 {
+		return ""
+	}
+	return normalized.String()
+}
+
+// normalizeIban removes spaces, and returns "" if the IBAN checksum (ISO 13616) is invalid.
+func normalizeIban(match string) string {
+	iban := strings.Replace(match, " ", "", -1)
+	if len(iban) < 15 || len(iban) > 34 {
+		return ""
+	}
+
+	//move the country code & check digits to the end, and convert letters to numbers (A = 10, B = 11, ...)
+	var numeric strings.Builder
+	for _, char := range iban[4:] + iban[:4] {
+		if char >= 'A' && char <= 'Z' {
+			numeric.WriteString(strconv.Itoa(int(char-'A') + 10))
+		} else {
+			numeric.WriteRune(char)
+		}
+	}
+	value, ok := new(big.Int).SetString(numeric.String(), 10)
+	if !ok || new(big.Int).Mod(value, big.NewInt(97)).Int64() != 1 {
+		return ""
+	}
+	return iban
+}
+
+// extractAccountNumbers returns the labelled account numbers in the content, without spaces or dashes.
+func extractAccountNumbers(content string) []string {
+	var accountNumbers []string
+	for _, match := range accountNumberPattern.FindAllStringSubmatch(content, -1) {
+		if len(accountNumbers) >= maxEntitiesPerType {
+			break
+		}
+		accountNumber := strings.NewReplacer(" ", "", "-", "").Replace(match[1])
+		if len(accountNumber) >= 6 && len(accountNumber) <= 20 && !containsString(accountNumbers, accountNumber) {
+			accountNumbers = append(accountNumbers, accountNumber)
+		}
+	}
+	return accountNumbers
+}
@@ -0,0 +1,104 @@
+package document
+
+import (
+	"testing"
+	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+const testInvoiceContent = `ACME Corp - Invoice 2019-0042
+Invoice date: April 15, 2019    Due: 15.05.2019
+Shipped 04/16/2019, delivered 17/04/2019. Not a date: 02/30/2019, version 1.2-3.2019, ambiguous 03/04/2019
+
+Subtotal: $1,234.56
+Shipping: 12.50 USD
+VAT (19%): 1.234,56 EUR
+Total due: $1,234.56
+
+Questions? Contact billing@ACME.example.com or call (555) 123-4567 / +44 20 7946 0958.
+Fax: 555.987.6543, please include your order number 555-123-9876.
+Pay online at https://pay.example.com/invoice?id=42.
+Bank: IBAN DE89 3704 0044 0532 0130 00 (invalid: DE89 3704 0044 0532 0130 01)
+Account No: 1234-5678-90`
+
+func TestDocumentProcessor_ExtractEntities(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{entityConfig: EntityConfig{Dates: true, Amounts: true, Emails: true, Phones: true, URLs: true, Accounts: true}}
+	doc := model.Document{Content: testInvoiceContent}
+
+	//test
+	proc.extractEntities(&doc)
+
+	//assert
+	require.Equal(t, []time.Time{
+		time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.May, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.April, 16, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.April, 17, 0, 0, 0, 0, time.UTC),
+	}, doc.Entities.Dates)
+	require.Equal(t, []model.DocAmount{
+		{Value: 1234.56, Currency: "USD"},
+		{Value: 12.5, Currency: "USD"},
+		{Value: 1234.56, Currency: "EUR"},
+	}, doc.Entities.Amounts)
+	require.Equal(t, []string{"billing@acme.example.com"}, doc.Entities.Emails)
+	require.Equal(t, []string{"5551234567", "+442079460958", "5559876543"}, doc.Entities.Phones)
+	require.Equal(t, []string{"https://pay.example.com/invoice?id=42"}, doc.Entities.URLs)
+	require.Equal(t, []string{"DE89370400440532013000"}, doc.Entities.Ibans)
+	require.Equal(t, []string{"1234567890"}, doc.Entities.AccountNumbers)
+}
+
+func TestDocumentProcessor_ExtractEntities_Disabled(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{entityConfig: EntityConfig{Emails: true}}
+	doc := model.Document{Content: testInvoiceContent}
+
+	//test
+	proc.extractEntities(&doc)
+
+	//assert
+	require.Equal(t, model.DocEntities{Emails: []string{"billing@acme.example.com"}}, doc.Entities)
+}
+
+func TestExtractDates_MonthNames(t *testing.T) {
+	require.Equal(t, []time.Time{
+		time.Date(2019, time.September, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.December, 24, 0, 0, 0, 0, time.UTC),
+	}, extractDates("Sept. 3, 2019 and 24 December 2019"))
+
+	//words starting with a month abbreviation are not months
+	require.Empty(t, extractDates("10 novels 2019"))
+	require.Empty(t, extractDates("3 decades 2019"))
+	require.Empty(t, extractDates("marketing 5, 2019"))
+}
+
+func TestExtractPhoneNumbers_Boundary(t *testing.T) {
+	require.Equal(t, []string{"+442079460958"}, extractPhoneNumbers("call +44 20 7946 0958"))
+
+	//a "+" within a word or number does not start a phone number
+	require.Empty(t, extractPhoneNumbers("1+44 20 7946 0958"))
+	require.Empty(t, extractPhoneNumbers("C+44 20 7946 0958"))
+	require.Empty(t, extractPhoneNumbers("x(555) 123-4567"))
+}
+
+func TestParseAmount(t *testing.T) {
+	var tests = []struct {
+		number   string
+		expected float64
+	}{
+		{"1,234.56", 1234.56},
+		{"1.234,56", 1234.56},
+		{"1,234", 1234},
+		{"1.234.567", 1234567},
+		{"1\u00a0234,5", 1234.5},
+		{"99", 99},
+	}
+
+	for _, tt := range tests {
+		actual, ok := parseAmount(tt.number)
+		require.True(t, ok, tt.number)
+		require.Equal(t, tt.expected, actual, tt.number)
+	}
+}
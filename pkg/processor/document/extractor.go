@@ -0,0 +1,137 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tika/tika"
+	"github.com/sirupsen/logrus"
+)
+
+// Extractor extracts the content & metadata of a document, and of any resources embedded in it. Resources use the
+// format of the Tika recursive metadata endpoint: one map per resource (the document itself first), with the content
+// stored as XHTML in the X-TIKA:content key. This way every extractor shares the same metadata mapping & page handling.
+type Extractor interface {
+	// Name is stored in file.extraction_method
+	Name() string
+
+	// Supports returns true if the extractor can handle the document, based on its file name or content type
+	Supports(fileName string, contentType string) bool
+
+	Extract(bucketPath string, source *documentSource, ocr ocrSettings) ([]map[string][]string, error)
+}
+
+// extractors returns the extractors supporting the document, in order of preference. Tika handles every format, the
+// native extractors only handle simple text formats, and are used before Tika (when native extraction is enabled), or
+// as a fallback when Tika fails or is unavailable.
+func (dp *DocumentProcessor) extractors(fileName string, contentType string) []Extractor {
+	nativeExtractor := &nativeExtractor{maxBytes: dp.contentLimits.TikaMaxBytes}
+	if !nativeExtractor.Supports(fileName, contentType) {
+		return []Extractor{dp.tikaExtractor()}
+	}
+	if dp.nativeExtraction {
+		return []Extractor{nativeExtractor, dp.tikaExtractor()}
+	}
+
+	//dont wait for tika to recover, the native extractor can be used instead
+	tikaExtractor := dp.tikaExtractor()
+	tikaExtractor.failFast = true
+	return []Extractor{tikaExtractor, nativeExtractor}
+}
+
+func (dp *DocumentProcessor) tikaExtractor() *tikaExtractor {
+	return &tikaExtractor{pool: dp.tika, maxBytes: dp.contentLimits.TikaMaxBytes, logger: dp.logger}
+}
+
+// requiresTika returns true if the document can only be extracted by Tika
+func (dp *DocumentProcessor) requiresTika(fileName string, contentType string) bool {
+	return !(&nativeExtractor{}).Supports(fileName, contentType)
+}
+
+// extract the document using the first supporting extractor that succeeds. Returns the resources, and the name of the
+// extractor that was used.
+func (dp *DocumentProcessor) extract(bucketPath string, contentType string, source *documentSource, ocr ocrSettings) ([]map[string][]string, string, error) {
+	var lastErr error
+	for _, extractor := range dp.extractors(bucketPath, contentType) {
+		if lastErr != nil {
+			dp.logger.Warnf("Extraction failed, falling back to %s extractor (%s): %v", extractor.Name(), bucketPath, lastErr)
+		}
+
+		resources, err := extractor.Extract(bucketPath, source, ocr)
+		if err == nil {
+			return resources, extractor.Name(), nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no extractor available for %s", bucketPath)
+	}
+	return nil, "", lastErr
+}
+
+// tikaExtractor sends the document to tika, using the recursive metadata endpoint, which returns the content &
+// metadata of the document, and every embedded resource (archive members, email attachments, etc) as a separate entry.
+type tikaExtractor struct {
+	pool     *tikaPool
+	maxBytes int64
+	logger   *logrus.Entry
+
+	//return errNoHealthyTikaServers instead of waiting for a tika server to recover
+	failFast bool
+}
+
+func (te *tikaExtractor) Name() string {
+	return extractionMethodTika
+}
+
+func (te *tikaExtractor) Supports(fileName string, contentType string) bool {
+	return true
+}
+
+func (te *tikaExtractor) Extract(bucketPath string, source *documentSource, ocr ocrSettings) ([]map[string][]string, error) {
+	do := te.pool.do
+	if te.failFast {
+		do = te.pool.tryDo
+	}
+
+	var resources []map[string][]string
+	err := do(ocr, func(client *tika.Client) error {
+		//the file is reopened for every attempt, as the request body is consumed
+		docFile, err := source.open()
+		if err != nil {
+			return err
+		}
+		defer docFile.Close()
+
+		//only send the first bytes of very large files to tika
+		var tikaInput io.Reader = docFile
+		if te.maxBytes > 0 {
+			tikaInput = io.LimitReader(docFile, te.maxBytes)
+		}
+
+		resources, err = client.MetaRecursiveType(context.Background(), tikaInput, "xml")
+		return err
+	})
+
+	//the file size is only known once the download is complete
+	if downloadErr := source.wait(); downloadErr != nil {
+		return nil, downloadErr
+	}
+	fileTruncated := te.maxBytes > 0 && source.size > te.maxBytes
+	if fileTruncated {
+		te.logger.Infof("File is larger than %d bytes, only the start of the file was parsed (%s)", te.maxBytes, bucketPath)
+	}
+	if err != nil && fileTruncated && err != errNoHealthyTikaServers {
+		//most binary formats cannot be parsed when truncated, index the file without content instead of failing.
+		te.logger.Warnf("Tika could not parse truncated file, indexing without content (%s): %v", bucketPath, err)
+		resources, err = []map[string][]string{{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("tika did not return any content for %s", bucketPath)
+	}
+	return resources, nil
+}
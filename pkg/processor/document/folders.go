@@ -0,0 +1,208 @@
+package document
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/markbates/pkger"
+)
+
+// ensureFolderIndex creates the folder index on startup. Folders are derived from the stored documents, so the folder
+// index is not versioned.
+func (dp *DocumentProcessor) ensureFolderIndex() error {
+	exists, err := dp.indexExists(dp.elasticsearchFolderIndex)
+	if err != nil {
+		return err
+	}
+	if exists {
+		dp.logger.Debugf("Folder index %s already exists, skipping.", dp.elasticsearchFolderIndex)
+		return nil
+	}
+
+	folderSettingsFile, err := pkger.Open("/static/document-processor/settings_folder.json")
+	if err != nil {
+		return err
+	}
+	defer folderSettingsFile.Close()
+
+	var folderSettings map[string]interface{}
+	if err := json.NewDecoder(folderSettingsFile).Decode(&folderSettings); err != nil {
+		return fmt.Errorf("could not parse folder index settings file: %v", err)
+	}
+
+	dp.logger.Printf("Creating %s folder index", dp.elasticsearchFolderIndex)
+	return dp.createIndex(dp.elasticsearchFolderIndex, folderSettings)
+}
+
+// storeFolders creates the folder (and all its ancestors) in the folder index. Existing folders are left as-is.
+func (dp *DocumentProcessor) storeFolders(bucketName string, folder string) error {
+	folders := newFolders(bucketName, folder)
+	if len(folders) == 0 {
+		return nil
+	}
+
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	for _, folder := range folders {
+		action := map[string]interface{}{"create": map[string]interface{}{"_index": dp.elasticsearchFolderIndex, "_id": folderID(folder)}}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(folder); err != nil {
+			return err
+		}
+	}
+
+	resp, err := dp.elasticsearchClient.Bulk(bytes.NewReader(payload.Bytes()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return fmt.Errorf("an error occurred while storing folders: %s", resp.String())
+	}
+
+	var bulkResult struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&bulkResult); err != nil {
+		return err
+	}
+	if !bulkResult.Errors {
+		return nil
+	}
+	for _, item := range bulkResult.Items {
+		for _, result := range item {
+			//409: the folder already exists
+			if result.Status >= 300 && result.Status != http.StatusConflict {
+				return fmt.Errorf("an error occurred while storing folders: %s", result.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// pruneFolders removes the folder (and its ancestors) from the folder index, once they no longer contain any
+// documents. Must be called after the deleted documents are visible to searches (ie. after a refresh).
+func (dp *DocumentProcessor) pruneFolders(bucketName string, folder string) error {
+	folders := newFolders(bucketName, folder)
+	for i := len(folders) - 1; i >= 0; i-- {
+		documents, err := dp.countFolderDocuments(bucketName, folders[i].Real)
+		if err != nil {
+			return err
+		}
+		if documents > 0 {
+			//the ancestors contain this folder, so they are not empty either
+			return nil
+		}
+
+		dp.logger.Infof("Removing empty folder %s", folders[i].Virtual)
+		resp, err := dp.elasticsearchClient.Delete(dp.elasticsearchFolderIndex, folderID(folders[i]))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.IsError() && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("an error occurred while removing folder %s: %s", folders[i].Virtual, resp.String())
+		}
+	}
+	return nil
+}
+
+// deleteFolderTree removes the folder and all its subfolders from the folder index.
+func (dp *DocumentProcessor) deleteFolderTree(bucketName string, folder string) error {
+	_, err := dp.deleteByQuery(dp.elasticsearchFolderIndex, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"root": bucketName}},
+				map[string]interface{}{"bool": map[string]interface{}{
+					"should": []interface{}{
+						map[string]interface{}{"term": map[string]interface{}{"real": folder}},
+						map[string]interface{}{"prefix": map[string]interface{}{"real": folder + "/"}},
+					},
+				}},
+			},
+		},
+	}, false)
+	return err
+}
+
+// countFolderDocuments counts the documents stored in the folder, or any of its subfolders.
+func (dp *DocumentProcessor) countFolderDocuments(bucketName string, folder string) (int, error) {
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"storage.bucket": bucketName}},
+					map[string]interface{}{"bool": map[string]interface{}{
+						"should": []interface{}{
+							map[string]interface{}{"term": map[string]interface{}{"storage.folder": folder}},
+							map[string]interface{}{"prefix": map[string]interface{}{"storage.folder": folder + "/"}},
+						},
+					}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := dp.elasticsearchClient.Count(
+		dp.elasticsearchClient.Count.WithIndex(dp.elasticsearchIndex),
+		dp.elasticsearchClient.Count.WithBody(bytes.NewReader(query)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return 0, fmt.Errorf("an error occurred while counting documents in folder %s: %s", folder, resp.String())
+	}
+
+	var countResult struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&countResult); err != nil {
+		return 0, err
+	}
+	return countResult.Count, nil
+}
+
+// newFolders returns the folder and its ancestors, starting with the top level folder.
+func newFolders(bucketName string, folder string) []model.Folder {
+	folders := []model.Folder{}
+	parent := ""
+	for _, name := range strings.Split(folder, "/") {
+		if name == "" {
+			continue
+		}
+		real := path.Join(parent, name)
+		folders = append(folders, model.Folder{
+			Root:    bucketName,
+			Real:    real,
+			Virtual: bucketName + "/" + real,
+			Parent:  parent,
+			Name:    name,
+		})
+		parent = real
+	}
+	return folders
+}
+
+// folder IDs are derived from the virtual path, which may be longer than the 512 byte ID limit.
+func folderID(folder model.Folder) string {
+	idHash := sha256.Sum256([]byte(folder.Virtual))
+	return hex.EncodeToString(idHash[:])
+}
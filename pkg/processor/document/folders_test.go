@@ -0,0 +1,135 @@
+package document
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFolderProcessor(t *testing.T, handler http.HandlerFunc) (DocumentProcessor, func()) {
+	server := httptest.NewServer(handler)
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	require.NoError(t, err)
+	return DocumentProcessor{
+		elasticsearchClient:      es,
+		elasticsearchIndex:       "lodestone",
+		elasticsearchFolderIndex: "lodestone-folders",
+		logger:                   logrus.WithField("test", t.Name()),
+	}, server.Close
+}
+
+func TestNewFolders(t *testing.T) {
+	require.Equal(t, []model.Folder{
+		{Root: "documents", Real: "taxes", Virtual: "documents/taxes", Parent: "", Name: "taxes"},
+		{Root: "documents", Real: "taxes/2019", Virtual: "documents/taxes/2019", Parent: "taxes", Name: "2019"},
+	}, newFolders("documents", "taxes/2019"))
+	require.Empty(t, newFolders("documents", ""))
+}
+
+func TestDocumentProcessor_StoreFolders(t *testing.T) {
+	//setup
+	var actions []map[string]map[string]string
+	var folders []model.Folder
+	proc, closeServer := newTestFolderProcessor(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_bulk", r.URL.Path)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+			actions = append(actions, action)
+			require.True(t, scanner.Scan())
+			var folder model.Folder
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &folder))
+			folders = append(folders, folder)
+		}
+		//the top level folder already exists
+		fmt.Fprint(w, `{"errors":true,"items":[
+			{"create":{"status":409,"error":{"type":"version_conflict_engine_exception"}}},
+			{"create":{"status":201}}
+		]}`)
+	})
+	defer closeServer()
+
+	//test
+	err := proc.storeFolders("documents", "taxes/2019")
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	require.Equal(t, "lodestone-folders", actions[0]["create"]["_index"])
+	require.Equal(t, folderID(folders[0]), actions[0]["create"]["_id"])
+	require.Equal(t, "documents/taxes", folders[0].Virtual)
+	require.Equal(t, "documents/taxes/2019", folders[1].Virtual)
+}
+
+func TestDocumentProcessor_StoreFolders_Error(t *testing.T) {
+	proc, closeServer := newTestFolderProcessor(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errors":true,"items":[{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`)
+	})
+	defer closeServer()
+
+	err := proc.storeFolders("documents", "taxes")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "mapper_parsing_exception")
+}
+
+func TestDocumentProcessor_PruneFolders(t *testing.T) {
+	//setup
+	countedFolders := []string{}
+	deletedFolders := []string{}
+	proc, closeServer := newTestFolderProcessor(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			deletedFolders = append(deletedFolders, r.URL.Path)
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `{"result":"deleted"}`)
+		default:
+			require.Equal(t, "/lodestone/_count", r.URL.Path)
+			body, _ := ioutil.ReadAll(r.Body)
+			var query struct {
+				Query struct {
+					Bool struct {
+						Filter []struct {
+							Bool struct {
+								Should []struct {
+									Term map[string]string `json:"term"`
+								} `json:"should"`
+							} `json:"bool"`
+						} `json:"filter"`
+					} `json:"bool"`
+				} `json:"query"`
+			}
+			require.NoError(t, json.Unmarshal(body, &query))
+			folder := query.Query.Bool.Filter[1].Bool.Should[0].Term["storage.folder"]
+			countedFolders = append(countedFolders, folder)
+
+			//taxes still contains taxes/2018
+			count := 0
+			if folder == "taxes" {
+				count = 3
+			}
+			fmt.Fprintf(w, `{"count":%d}`, count)
+		}
+	})
+	defer closeServer()
+
+	//test
+	err := proc.pruneFolders("documents", "taxes/2019/receipts")
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, []string{"taxes/2019/receipts", "taxes/2019", "taxes"}, countedFolders)
+	require.Equal(t, []string{
+		"/lodestone-folders/_doc/" + folderID(model.Folder{Virtual: "documents/taxes/2019/receipts"}),
+		"/lodestone-folders/_doc/" + folderID(model.Folder{Virtual: "documents/taxes/2019"}),
+	}, deletedFolders)
+}
@@ -0,0 +1,317 @@
+package document
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markbates/pkger"
+)
+
+// Documents are stored in versioned physical indices (eg. "lodestone-3f2a9c1b04de"), and are always accessed via an
+// alias with the configured index name ("lodestone"). The version is derived from the contents of the index settings
+// file, so any change to settings.json (or the override file) results in a new physical index. Documents are reindexed
+// into the new index, and the alias is swapped atomically, so the webapp never sees a missing or half-populated index.
+const indexVersionLength = 12
+
+// errIndexAlreadyExists is returned when creating an index that already exists, eg. because another processor instance
+// created it concurrently.
+var errIndexAlreadyExists = errors.New("index already exists")
+
+// ensureIndicies makes sure the alias points at an index created with the current settings. When force is true, the
+// documents are reindexed into a new physical index even if the settings have not changed.
+func (dp *DocumentProcessor) ensureIndicies(force bool) error {
+	indexSettings, err := dp.loadIndexSettings()
+	if err != nil {
+		return err
+	}
+	//custom metadata fields are part of the mapping, changing their types results in a new index version
+	dp.metadataMapping.addIndexMappings(indexSettings)
+
+	version, err := indexSettingsVersion(indexSettings)
+	if err != nil {
+		return err
+	}
+	targetIndex := fmt.Sprintf("%s-%s", dp.elasticsearchIndex, version)
+
+	dp.logger.Printf("Attempting to ensure %s alias points to %s index", dp.elasticsearchIndex, targetIndex)
+	currentIndices, err := dp.aliasedIndices()
+	if err != nil {
+		return err
+	}
+
+	if len(currentIndices) == 1 && currentIndices[0] == targetIndex && !force {
+		//index exists, make sure it is not left write blocked by an interrupted migration
+		dp.logger.Println("Index already exists and is up-to-date, skipping.")
+		return dp.setWriteBlock(currentIndices, false)
+	}
+
+	if len(currentIndices) == 0 {
+		legacyIndex, err := dp.indexExists(dp.elasticsearchIndex)
+		if err != nil {
+			return err
+		}
+		if legacyIndex {
+			//index created by an older version of the processor, before indices were versioned. Migrate it.
+			currentIndices = []string{dp.elasticsearchIndex}
+		}
+	}
+
+	if force && len(currentIndices) == 1 && currentIndices[0] == targetIndex {
+		//settings have not changed, but a reindex was requested. Use a unique name for the new physical index.
+		targetIndex = fmt.Sprintf("%s-%d", targetIndex, time.Now().Unix())
+	}
+
+	if len(currentIndices) == 0 {
+		//fresh install, create the index and alias in a single step.
+		dp.logger.Printf("Creating %s index", targetIndex)
+		indexSettings["aliases"] = map[string]interface{}{dp.elasticsearchIndex: map[string]interface{}{}}
+		err := dp.createIndex(targetIndex, indexSettings)
+		if err == errIndexAlreadyExists {
+			dp.logger.Printf("Index %s was created by another instance, skipping.", targetIndex)
+			return nil
+		}
+		return err
+	}
+
+	dp.logger.Printf("Index settings changed, migrating documents from %v to %s", currentIndices, targetIndex)
+	return dp.migrateIndices(currentIndices, targetIndex, indexSettings)
+}
+
+// migrateIndices reindexes the documents of the current indices into the target index, and swaps the alias. The
+// current indices are write blocked during the migration, so documents written via the alias (by the processor or the
+// webapp) are rejected rather than silently lost when the old indices are removed. When the migration fails, the target
+// index is removed and the current indices are writable again.
+//
+// Only the instance that creates the target index migrates the documents. When the target index already exists, another
+// instance is migrating them (or a previous migration was interrupted, the index has to be removed manually), and it is
+// left untouched.
+func (dp *DocumentProcessor) migrateIndices(currentIndices []string, targetIndex string, indexSettings map[string]interface{}) error {
+	err := dp.createIndex(targetIndex, indexSettings)
+	if err == errIndexAlreadyExists {
+		dp.logger.Warnf("Index %s already exists, skipping migration. Another instance is migrating the documents, or a previous migration was interrupted (remove the index to retry).", targetIndex)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = dp.setWriteBlock(currentIndices, true)
+	if err == nil {
+		err = dp.reindex(currentIndices, targetIndex)
+	}
+	if err == nil {
+		//the old indices are removed when the alias is swapped
+		err = dp.swapAlias(currentIndices, targetIndex)
+	}
+	if err == nil {
+		return nil
+	}
+
+	dp.logger.Errorf("Migration to %s failed, restoring %v: %v", targetIndex, currentIndices, err)
+	if deleteErr := dp.deleteIndex(targetIndex); deleteErr != nil {
+		dp.logger.Warnf("Could not remove incomplete %s index: %v", targetIndex, deleteErr)
+	}
+	if blockErr := dp.setWriteBlock(currentIndices, false); blockErr != nil {
+		dp.logger.Errorf("Could not remove write block from %v: %v", currentIndices, blockErr)
+	}
+	return err
+}
+
+func (dp *DocumentProcessor) loadIndexSettings() (map[string]interface{}, error) {
+	var indexSettingsFile io.ReadCloser
+	var err error
+
+	if dp.elasticsearchMappingOverride == "" {
+		indexSettingsFile, err = pkger.Open("/static/document-processor/settings.json")
+	} else {
+		indexSettingsFile, err = os.Open(dp.elasticsearchMappingOverride)
+	}
+
+	if err != nil {
+		dp.logger.Printf("COULD NOT OPEN MAPPING OVERRIDE FILE: %v", err)
+		return nil, err
+	}
+	defer indexSettingsFile.Close()
+
+	var indexSettings map[string]interface{}
+	err = json.NewDecoder(indexSettingsFile).Decode(&indexSettings)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse index settings file: %v", err)
+	}
+	return indexSettings, nil
+}
+
+// the version is a checksum of the (canonical) json encoded settings, so formatting changes do not trigger a reindex.
+func indexSettingsVersion(indexSettings map[string]interface{}) (string, error) {
+	canonicalSettings, err := json.Marshal(indexSettings)
+	if err != nil {
+		return "", err
+	}
+	checksum := sha256.Sum256(canonicalSettings)
+	return hex.EncodeToString(checksum[:])[:indexVersionLength], nil
+}
+
+// returns the physical indices the alias currently points to, sorted by name.
+func (dp *DocumentProcessor) aliasedIndices() ([]string, error) {
+	resp, err := dp.elasticsearchClient.Indices.GetAlias(dp.elasticsearchClient.Indices.GetAlias.WithName(dp.elasticsearchIndex))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	} else if resp.IsError() {
+		return nil, fmt.Errorf("an error occurred while retrieving %s alias: %s", dp.elasticsearchIndex, resp.String())
+	}
+
+	var aliases map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&aliases); err != nil {
+		return nil, err
+	}
+
+	indices := []string{}
+	for index := range aliases {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+func (dp *DocumentProcessor) indexExists(index string) (bool, error) {
+	resp, err := dp.elasticsearchClient.Indices.Exists([]string{index})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (dp *DocumentProcessor) deleteIndex(index string) error {
+	resp, err := dp.elasticsearchClient.Indices.Delete([]string{index})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("an error occurred while removing %s index: %s", index, resp.String())
+	}
+	return nil
+}
+
+// setWriteBlock makes the indices read-only (or writable again)
+func (dp *DocumentProcessor) setWriteBlock(indices []string, blocked bool) error {
+	payload, err := json.Marshal(map[string]interface{}{"index.blocks.write": blocked})
+	if err != nil {
+		return err
+	}
+
+	resp, err := dp.elasticsearchClient.Indices.PutSettings(bytes.NewReader(payload), dp.elasticsearchClient.Indices.PutSettings.WithIndex(indices...))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return fmt.Errorf("an error occurred while updating the write block of %v: %s", indices, resp.String())
+	}
+	return nil
+}
+
+func (dp *DocumentProcessor) createIndex(index string, indexSettings map[string]interface{}) error {
+	payload, err := json.Marshal(compatibleIndexSettings(indexSettings))
+	if err != nil {
+		return err
+	}
+
+	resp, err := dp.elasticsearchClient.Indices.Create(index, dp.elasticsearchClient.Indices.Create.WithBody(bytes.NewReader(payload)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		if strings.Contains(string(respBody), "resource_already_exists_exception") {
+			return errIndexAlreadyExists
+		}
+		return fmt.Errorf("an error occurred while creating %s index: [%s] %s", index, resp.Status(), respBody)
+	}
+	return nil
+}
+
+func (dp *DocumentProcessor) reindex(sourceIndices []string, targetIndex string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"source": map[string]interface{}{"index": sourceIndices},
+		"dest":   map[string]interface{}{"index": targetIndex},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := dp.elasticsearchClient.Reindex(
+		bytes.NewReader(payload),
+		dp.elasticsearchClient.Reindex.WithWaitForCompletion(true),
+		dp.elasticsearchClient.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("an error occurred while reindexing into %s: %s", targetIndex, respBody)
+	}
+
+	var reindexResult struct {
+		Total    int               `json:"total"`
+		Created  int               `json:"created"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.Unmarshal(respBody, &reindexResult); err != nil {
+		return err
+	}
+	if len(reindexResult.Failures) > 0 {
+		return fmt.Errorf("%d documents failed to reindex into %s: %s", len(reindexResult.Failures), targetIndex, reindexResult.Failures[0])
+	}
+	dp.logger.Printf("Reindexed %d documents into %s", reindexResult.Total, targetIndex)
+	return nil
+}
+
+// atomically point the alias at the new index, and remove the old indices.
+func (dp *DocumentProcessor) swapAlias(oldIndices []string, targetIndex string) error {
+	actions := []map[string]interface{}{
+		{"add": map[string]interface{}{"index": targetIndex, "alias": dp.elasticsearchIndex}},
+	}
+	for _, oldIndex := range oldIndices {
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": oldIndex}})
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	resp, err := dp.elasticsearchClient.Indices.UpdateAliases(bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return fmt.Errorf("an error occurred while swapping %s alias to %s: %s", dp.elasticsearchIndex, targetIndex, resp.String())
+	}
+	dp.logger.Printf("Alias %s now points to %s", dp.elasticsearchIndex, targetIndex)
+	return nil
+}
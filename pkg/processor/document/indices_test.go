@@ -0,0 +1,259 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testIndexSettingsPath = "../../../static/document-processor/settings.json"
+
+// indicesStub configures the responses of the stub elasticsearch server
+type indicesStub struct {
+	aliasResponse   string   //response of the alias request, the alias does not exist when empty
+	existingIndices []string //indices that exist (in addition to the aliased indices)
+	reindexResponse string
+}
+
+// stub elasticsearch server, which records every request
+func newIndicesStub(t *testing.T, stub indicesStub, requests *[]string, bodies map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.URL.Path
+		*requests = append(*requests, request)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) > 0 {
+			var parsedBody map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &parsedBody))
+			bodies[request] = parsedBody
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/_alias/lodestone":
+			if stub.aliasResponse == "" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error":"alias [lodestone] missing","status":404}`)
+				return
+			}
+			fmt.Fprint(w, stub.aliasResponse)
+		case r.Method == http.MethodHead:
+			for _, index := range stub.existingIndices {
+				if r.URL.Path == "/"+index {
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut && containsString(stub.existingIndices, strings.TrimPrefix(r.URL.Path, "/")):
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":{"type":"resource_already_exists_exception","reason":"index [%s] already exists"},"status":400}`, r.URL.Path)
+		case r.URL.Path == "/_reindex":
+			if stub.reindexResponse != "" {
+				fmt.Fprint(w, stub.reindexResponse)
+				return
+			}
+			fmt.Fprint(w, `{"total": 2, "created": 2, "failures": []}`)
+		default:
+			fmt.Fprint(w, `{"acknowledged": true}`)
+		}
+	}))
+}
+
+func newIndicesTestProcessor(t *testing.T, server *httptest.Server) (DocumentProcessor, string) {
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	require.NoError(t, err)
+
+	dp := DocumentProcessor{
+		elasticsearchClient:          es,
+		elasticsearchIndex:           "lodestone",
+		elasticsearchMappingOverride: testIndexSettingsPath,
+		logger:                       logrus.WithField("test", t.Name()),
+	}
+	return dp, testTargetIndex(t)
+}
+
+// the physical index name for the bundled settings.json
+func testTargetIndex(t *testing.T) string {
+	dp := DocumentProcessor{elasticsearchMappingOverride: testIndexSettingsPath}
+	indexSettings, err := dp.loadIndexSettings()
+	require.NoError(t, err)
+	version, err := indexSettingsVersion(indexSettings)
+	require.NoError(t, err)
+	return "lodestone-" + version
+}
+
+func TestDocumentProcessor_EnsureIndicies_FreshInstall(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	bodies := map[string]map[string]interface{}{}
+	server := newIndicesStub(t, indicesStub{}, &requests, bodies)
+	defer server.Close()
+	dp, targetIndex := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET /_alias/lodestone", "HEAD /lodestone", "PUT /" + targetIndex}, requests)
+	require.Equal(t, map[string]interface{}{"lodestone": map[string]interface{}{}}, bodies["PUT /"+targetIndex]["aliases"])
+}
+
+func TestDocumentProcessor_EnsureIndicies_UpToDate(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	aliasResponse := fmt.Sprintf(`{"%s": {"aliases": {"lodestone": {}}}}`, testTargetIndex(t))
+	bodies := map[string]map[string]interface{}{}
+	server := newIndicesStub(t, indicesStub{aliasResponse: aliasResponse}, &requests, bodies)
+	defer server.Close()
+	dp, targetIndex := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET /_alias/lodestone", "PUT /" + targetIndex + "/_settings"}, requests)
+	require.Equal(t, map[string]interface{}{"index.blocks.write": false}, bodies["PUT /"+targetIndex+"/_settings"])
+}
+
+func TestDocumentProcessor_EnsureIndicies_SettingsChanged(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	bodies := map[string]map[string]interface{}{}
+	server := newIndicesStub(t, indicesStub{aliasResponse: `{"lodestone-000000000000": {"aliases": {"lodestone": {}}}}`}, &requests, bodies)
+	defer server.Close()
+	dp, targetIndex := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"GET /_alias/lodestone",
+		"PUT /" + targetIndex,
+		"PUT /lodestone-000000000000/_settings",
+		"POST /_reindex",
+		"POST /_aliases",
+	}, requests)
+	require.Equal(t, map[string]interface{}{"index.blocks.write": true}, bodies["PUT /lodestone-000000000000/_settings"])
+	require.NotContains(t, bodies["PUT /"+targetIndex], "aliases")
+	require.Equal(t, map[string]interface{}{
+		"source": map[string]interface{}{"index": []interface{}{"lodestone-000000000000"}},
+		"dest":   map[string]interface{}{"index": targetIndex},
+	}, bodies["POST /_reindex"])
+	require.Equal(t, map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{"add": map[string]interface{}{"index": targetIndex, "alias": "lodestone"}},
+			map[string]interface{}{"remove_index": map[string]interface{}{"index": "lodestone-000000000000"}},
+		},
+	}, bodies["POST /_aliases"])
+}
+
+func TestDocumentProcessor_EnsureIndicies_LegacyIndex(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	bodies := map[string]map[string]interface{}{}
+	server := newIndicesStub(t, indicesStub{existingIndices: []string{"lodestone"}}, &requests, bodies)
+	defer server.Close()
+	dp, targetIndex := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"GET /_alias/lodestone",
+		"HEAD /lodestone",
+		"PUT /" + targetIndex,
+		"PUT /lodestone/_settings",
+		"POST /_reindex",
+		"POST /_aliases",
+	}, requests)
+	require.Equal(t, map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{"add": map[string]interface{}{"index": targetIndex, "alias": "lodestone"}},
+			map[string]interface{}{"remove_index": map[string]interface{}{"index": "lodestone"}},
+		},
+	}, bodies["POST /_aliases"])
+}
+
+func TestDocumentProcessor_EnsureIndicies_MigrationInProgress(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	targetIndex := testTargetIndex(t)
+	server := newIndicesStub(t, indicesStub{
+		aliasResponse:   `{"lodestone-000000000000": {"aliases": {"lodestone": {}}}}`,
+		existingIndices: []string{targetIndex},
+	}, &requests, map[string]map[string]interface{}{})
+	defer server.Close()
+	dp, _ := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.NoError(t, err)
+	//the index created by another instance is not removed, and its migration is not interfered with
+	require.Equal(t, []string{"GET /_alias/lodestone", "PUT /" + targetIndex}, requests)
+}
+
+func TestDocumentProcessor_EnsureIndicies_CreatedConcurrently(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	targetIndex := testTargetIndex(t)
+	server := newIndicesStub(t, indicesStub{existingIndices: []string{targetIndex}}, &requests, map[string]map[string]interface{}{})
+	defer server.Close()
+	dp, _ := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET /_alias/lodestone", "HEAD /lodestone", "PUT /" + targetIndex}, requests)
+}
+
+func TestDocumentProcessor_EnsureIndicies_ReindexFailed(t *testing.T) {
+
+	//setup
+	requests := []string{}
+	bodies := map[string]map[string]interface{}{}
+	server := newIndicesStub(t, indicesStub{
+		aliasResponse:   `{"lodestone-000000000000": {"aliases": {"lodestone": {}}}}`,
+		reindexResponse: `{"total": 2, "created": 1, "failures": [{"id": "checksum"}]}`,
+	}, &requests, bodies)
+	defer server.Close()
+	dp, targetIndex := newIndicesTestProcessor(t, server)
+
+	//test
+	err := dp.ensureIndicies(false)
+
+	//assert
+	require.Error(t, err)
+	require.Equal(t, []string{
+		"GET /_alias/lodestone",
+		"PUT /" + targetIndex,
+		"PUT /lodestone-000000000000/_settings",
+		"POST /_reindex",
+		"DELETE /" + targetIndex,
+		"PUT /lodestone-000000000000/_settings",
+	}, requests)
+	//the old index is writable again
+	require.Equal(t, map[string]interface{}{"index.blocks.write": false}, bodies["PUT /lodestone-000000000000/_settings"])
+}
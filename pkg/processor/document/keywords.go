@@ -0,0 +1,160 @@
+package document
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// KeywordConfig configures the automatic keywords stored in `lodestone.auto_keywords`, which are used for tag
+// suggestions and faceting. Keywords are extracted from the content using RAKE (Rapid Automatic Keyword Extraction):
+// candidate phrases are taken from the runs of words between stop words and punctuation, and are ranked by how often
+// their words occur in longer runs. A zero Count disables automatic keywords.
+type KeywordConfig struct {
+	// maximum number of keywords per document
+	Count int
+
+	// keywords must occur at least this many times in the content
+	MinOccurrences int
+}
+
+func (kc KeywordConfig) Validate() error {
+	if kc.Count < 0 {
+		return fmt.Errorf("keywords: count cannot be negative")
+	}
+	if kc.MinOccurrences < 0 {
+		return fmt.Errorf("keywords: min occurrences cannot be negative")
+	}
+	return nil
+}
+
+const (
+	// number of content characters used to extract keywords
+	keywordSampleChars = 50000
+
+	// longer phrases are usually sentence fragments, not keywords
+	keywordMaxPhraseWords = 3
+)
+
+// words (including inner apostrophes & hyphens, eg. "e-mail") and the punctuation or line breaks between them
+var keywordTokenPattern = regexp.MustCompile(`[\p{L}\p{N}]+(?:['’-][\p{L}\p{N}]+)*|[^\p{L}\p{N}\s]|\n`)
+
+// extractKeywords sets the automatic keywords of the document, ordered by rank. RAKE relies on stop words to split
+// phrases, so documents in languages without stop words do not get keywords.
+func (dp *DocumentProcessor) extractKeywords(doc *model.Document) {
+	if dp.keywordConfig.Count == 0 || doc.Content == "" {
+		return
+	}
+	stopWords, ok := languageStopWords(doc.Language)
+	if !ok {
+		return
+	}
+	sample, _ := truncateText(doc.Content, keywordSampleChars)
+	doc.Lodestone.AutoKeywords = rakeKeywords(sample, stopWords, dp.keywordConfig.Count, dp.keywordConfig.MinOccurrences)
+}
+
+func rakeKeywords(text string, stopWords map[string]bool, count int, minOccurrences int) []string {
+	//split the text into runs of words between stop words & punctuation
+	var runs [][]string
+	var run []string
+	for _, token := range keywordTokenPattern.FindAllString(strings.ToLower(text), -1) {
+		if isKeywordWord(token, stopWords) {
+			run = append(run, token)
+		} else if len(run) > 0 {
+			runs = append(runs, run)
+			run = nil
+		}
+	}
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+
+	//word score: degree (the number of words in the runs containing the word) / frequency
+	frequency := map[string]int{}
+	degree := map[string]int{}
+	for _, run := range runs {
+		for _, word := range run {
+			frequency[word]++
+			degree[word] += len(run)
+		}
+	}
+
+	//candidate phrases: every sequence of up to keywordMaxPhraseWords words within a run, so a phrase like "solar
+	//farm" is counted in both "solar farm produced" and "solar farm increased"
+	occurrences := map[string]int{}
+	var phrases [][]string
+	for _, run := range runs {
+		for start := range run {
+			for end := start + 1; end <= len(run) && end-start <= keywordMaxPhraseWords; end++ {
+				key := strings.Join(run[start:end], " ")
+				if occurrences[key] == 0 {
+					phrases = append(phrases, run[start:end])
+				}
+				occurrences[key]++
+			}
+		}
+	}
+
+	type keyword struct {
+		phrase string
+		score  float64
+	}
+	var keywords []keyword
+	for _, phrase := range phrases {
+		key := strings.Join(phrase, " ")
+		if occurrences[key] < minOccurrences {
+			continue
+		}
+		score := 0.0
+		for _, word := range phrase {
+			score += float64(degree[word]) / float64(frequency[word])
+		}
+		//phrases that are used more often are more representative of the document
+		keywords = append(keywords, keyword{key, score * (1 + math.Log(float64(occurrences[key])))})
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if keywords[i].score != keywords[j].score {
+			return keywords[i].score > keywords[j].score
+		}
+		return keywords[i].phrase < keywords[j].phrase
+	})
+
+	var selected []string
+	for _, keyword := range keywords {
+		if len(selected) == count {
+			break
+		}
+		//skip words & phrases that are part of a higher ranking phrase, eg. "farm" after "solar farm"
+		partOfSelected := false
+		for _, existing := range selected {
+			if strings.Contains(" "+existing+" ", " "+keyword.phrase+" ") {
+				partOfSelected = true
+				break
+			}
+		}
+		if !partOfSelected {
+			selected = append(selected, keyword.phrase)
+		}
+	}
+	return selected
+}
+
+// isKeywordWord returns false for punctuation, stop words, numbers and words shorter than 3 characters, which
+// separate candidate phrases.
+func isKeywordWord(token string, stopWords map[string]bool) bool {
+	if utf8.RuneCountInString(token) < 3 || stopWords[token] {
+		return false
+	}
+	for _, char := range token {
+		if unicode.IsLetter(char) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractKeywords(t *testing.T) {
+	//setup
+	dp := DocumentProcessor{keywordConfig: KeywordConfig{Count: 3, MinOccurrences: 2}}
+	doc := model.Document{Content: summaryTestContent}
+
+	//test
+	dp.extractKeywords(&doc)
+
+	//assert
+	require.Equal(t, []string{"solar farm", "quarter", "energy"}, doc.Lodestone.AutoKeywords)
+}
+
+func TestRakeKeywords(t *testing.T) {
+	content := "Compatibility of systems of linear constraints over the set of natural numbers. Criteria of " +
+		"compatibility of a system of linear Diophantine equations, strict inequations, and nonstrict inequations are " +
+		"considered. Upper bounds for components of a minimal set of solutions and algorithms of construction of " +
+		"minimal generating sets of solutions for all types of systems are given."
+
+	require.Equal(t,
+		[]string{"linear diophantine equations", "minimal generating sets", "linear constraints", "minimal set", "natural numbers"},
+		rakeKeywords(content, stopWordsByLanguage["en"], 5, 0),
+	)
+
+	//numbers, short words & stop words are not keywords
+	require.Nil(t, rakeKeywords("It is 2019, or 2020. Is it? So it is.", stopWordsByLanguage["en"], 5, 0))
+}
+
+func TestExtractKeywords_Language(t *testing.T) {
+	dp := DocumentProcessor{keywordConfig: KeywordConfig{Count: 3}}
+
+	doc := model.Document{Content: "Die Rechnung für die Solaranlage und die Rechnung für den Speicher.", Language: "de"}
+	dp.extractKeywords(&doc)
+	require.Equal(t, []string{"rechnung", "solaranlage", "speicher"}, doc.Lodestone.AutoKeywords)
+
+	//languages without stop words do not get keywords
+	doc = model.Document{Content: summaryTestContent, Language: "ja"}
+	dp.extractKeywords(&doc)
+	require.Nil(t, doc.Lodestone.AutoKeywords)
+}
+
+func TestExtractKeywords_Disabled(t *testing.T) {
+	dp := DocumentProcessor{}
+	doc := model.Document{Content: summaryTestContent}
+	dp.extractKeywords(&doc)
+	require.Nil(t, doc.Lodestone.AutoKeywords)
+}
+
+func TestMergeLodestone_AutoKeywords(t *testing.T) {
+	//automatic keywords are owned by the processor, and replaced on every update
+	merged := mergeLodestone(model.DocLodestone{AutoKeywords: []string{"old"}}, model.DocLodestone{AutoKeywords: []string{"new"}})
+	require.Equal(t, []string{"new"}, merged.AutoKeywords)
+}
+
+func TestKeywordConfig_Validate(t *testing.T) {
+	require.NoError(t, KeywordConfig{Count: 10, MinOccurrences: 2}.Validate())
+	require.Error(t, KeywordConfig{Count: -1}.Validate())
+	require.Error(t, KeywordConfig{MinOccurrences: -1}.Validate())
+}
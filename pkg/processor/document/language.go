@@ -0,0 +1,54 @@
+package document
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/google/go-tika/tika"
+)
+
+// minimum number of content characters required to reliably detect the language of a document
+const languageDetectionMinChars = 50
+
+// number of content characters sent to Tika to detect the language of a document
+const languageDetectionSampleChars = 10000
+
+// ISO 639-1 codes, optionally followed by a region (eg. "en", "en-US", "pt_BR")
+var languageCodePattern = regexp.MustCompile(`^([a-zA-Z]{2})(?:[-_][a-zA-Z0-9]+)?$`)
+
+// detectLanguage sets the detected content language of a document (ISO 639-1 code). The content of documents in one of
+// the model.ContentLanguages is also indexed into a `content_<language>` field with a language specific analyzer, so
+// search clients can query the field matching the language of each document.
+//
+// Short documents cannot be detected reliably, so we fall back to the language specified in the document metadata.
+func (dp *DocumentProcessor) detectLanguage(doc *model.Document) {
+	//language detection is optional, dont wait for tika when it is unavailable (eg. natively extracted documents)
+	if dp.detectLanguages && utf8.RuneCountInString(doc.Content) >= languageDetectionMinChars && dp.tika.healthyServers() > 0 {
+		sample, _ := truncateText(doc.Content, languageDetectionSampleChars)
+		var language string
+		err := dp.tika.do(ocrSettings{}, func(client *tika.Client) error {
+			var err error
+			language, err = client.LanguageString(context.Background(), sample)
+			return err
+		})
+		if err != nil {
+			dp.logger.Warnf("An error occurred while detecting document language: %v", err)
+		} else if language = normalizeLanguageCode(language); language != "" {
+			doc.Language = language
+			return
+		}
+	}
+	doc.Language = normalizeLanguageCode(doc.Meta.Language)
+}
+
+// normalizeLanguageCode converts a language tag into a lowercase ISO 639-1 code, or "" if it is not a valid tag.
+func normalizeLanguageCode(language string) string {
+	matches := languageCodePattern.FindStringSubmatch(strings.TrimSpace(language))
+	if matches == nil {
+		return ""
+	}
+	return strings.ToLower(matches[1])
+}
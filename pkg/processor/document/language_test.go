@@ -0,0 +1,103 @@
+package document
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLanguageCode(t *testing.T) {
+	var tests = []struct {
+		language string
+		expected string
+	}{
+		{"en", "en"},
+		{"DE", "de"},
+		{"en-US", "en"},
+		{"pt_BR", "pt"},
+		{" fr ", "fr"},
+		{"", ""},
+		{"english", ""},
+		{"x-unknown", ""},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, normalizeLanguageCode(tt.language), "language: %q", tt.language)
+	}
+}
+
+func TestDocumentProcessor_DetectLanguage(t *testing.T) {
+	//setup
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/language/string", r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(body), "Die Steuererklärung"))
+		fmt.Fprint(w, "de")
+	}))
+	defer tikaServer.Close()
+	proc := DocumentProcessor{detectLanguages: true, tika: newTestTikaPool(t, tikaServer.URL), logger: logrus.WithField("test", t.Name())}
+
+	doc := model.Document{
+		Content: "Die Steuererklärung für das Jahr 2019 muss bis zum 31. Juli eingereicht werden.",
+		Meta:    model.DocMeta{Language: "en-US"},
+	}
+
+	//test
+	proc.detectLanguage(&doc)
+
+	//assert
+	require.Equal(t, "de", doc.Language)
+	require.Equal(t, "en-US", doc.Meta.Language, "metadata language is not modified")
+}
+
+func TestDocumentProcessor_DetectLanguage_MetadataFallback(t *testing.T) {
+	//setup
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tikaServer.Close()
+
+	var tests = []struct {
+		detectLanguages bool
+		content         string
+	}{
+		{true, "too short"},
+		{true, strings.Repeat("tika is not available ", 5)},
+		{false, strings.Repeat("detection is disabled ", 5)},
+	}
+
+	for _, tt := range tests {
+		proc := DocumentProcessor{detectLanguages: tt.detectLanguages, tika: newTestTikaPool(t, tikaServer.URL), logger: logrus.WithField("test", t.Name())}
+		doc := model.Document{Content: tt.content, Meta: model.DocMeta{Language: "fr-FR"}}
+
+		//test
+		proc.detectLanguage(&doc)
+
+		//assert
+		require.Equal(t, "fr", doc.Language, "content: %q", tt.content)
+	}
+}
+
+func TestContentLanguagesMapping(t *testing.T) {
+	//setup
+	dp := DocumentProcessor{elasticsearchMappingOverride: testIndexSettingsPath}
+
+	//test
+	indexSettings, err := dp.loadIndexSettings()
+
+	//assert
+	require.NoError(t, err)
+	properties := nestedSettingsMap(indexSettings, "mappings", "properties")
+	for _, language := range model.ContentLanguages {
+		require.Contains(t, properties, "content_"+language, "every content language needs a field with a language specific analyzer")
+	}
+}
@@ -0,0 +1,178 @@
+package document
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+const (
+	// number of content characters written to the debug log
+	debugContentPreviewChars = 500
+
+	// maximum number of pages stored in a document. Every page is a nested (hidden Lucene) document, and Elasticsearch
+	// rejects documents with more than `index.mapping.nested_objects.limit` (10,000 by default) nested documents. The
+	// content of the remaining pages is still searchable in `content`, without page numbers.
+	maxIndexedPages = 1000
+)
+
+// ContentLimits protects Tika & Elasticsearch from very large documents. A zero value disables the limit.
+type ContentLimits struct {
+	// maximum number of bytes of a file that are sent to Tika. Larger files are truncated, which works well for text
+	// formats. Other formats may not be parseable when truncated, in which case they are indexed without content.
+	TikaMaxBytes int64
+
+	// maximum number of content characters stored in a document (and its pages). Elasticsearch will not highlight
+	// fields larger than `index.highlight.max_analyzed_offset` (1,000,000 characters by default)
+	MaxIndexedChars int
+
+	// split the complete content of documents larger than this many characters into separate chunk records, linked to
+	// the document using `parent_id`. Chunks are not affected by MaxIndexedChars.
+	ChunkSize int
+}
+
+func (cl ContentLimits) Validate() error {
+	if cl.TikaMaxBytes < 0 {
+		return fmt.Errorf("content limits: tika max bytes cannot be negative")
+	}
+	if cl.MaxIndexedChars < 0 {
+		return fmt.Errorf("content limits: max indexed characters cannot be negative")
+	}
+	if cl.ChunkSize < 0 {
+		return fmt.Errorf("content limits: chunk size cannot be negative")
+	}
+	return nil
+}
+
+// applyContentLimits truncates the content of a document to the configured maximum, and returns the document followed
+// by its chunk records (if chunking is enabled and the content is large enough to be chunked).
+func (dp *DocumentProcessor) applyContentLimits(doc model.Document) []model.Document {
+	doc.Pages = limitPages(doc.Pages)
+
+	var chunks []model.Document
+	if dp.contentLimits.ChunkSize > 0 && utf8.RuneCountInString(doc.Content) > dp.contentLimits.ChunkSize {
+		for i, chunkContent := range splitChunks(doc.Content, dp.contentLimits.ChunkSize) {
+			chunks = append(chunks, contentChunk(doc, i+1, chunkContent))
+		}
+	}
+
+	if content, truncated := truncateText(doc.Content, dp.contentLimits.MaxIndexedChars); truncated {
+		dp.logger.Infof("Truncating content of %s to %d characters", doc.Storage.Path, dp.contentLimits.MaxIndexedChars)
+		doc.Content = content
+		doc.Pages = truncatePages(doc.Pages, dp.contentLimits.MaxIndexedChars)
+		doc.File.IndexedChars = int64(len(content))
+		doc.File.Truncated = true
+	}
+
+	return append([]model.Document{doc}, chunks...)
+}
+
+// contentChunk creates a chunk record for part of the content of a document. Chunks are stored with the same storage
+// bucket & path as the document, so deleting the file also deletes its chunks.
+func contentChunk(doc model.Document, number int, content string) model.Document {
+	idHash := sha256.Sum256([]byte(doc.ID + "#chunk" + strconv.Itoa(number)))
+
+	file := doc.File
+	file.IndexedChars = int64(len(content))
+	file.Truncated = false
+
+	return model.Document{
+		ID:       hex.EncodeToString(idHash[:]),
+		Content:  content,
+		Language: doc.Language,
+		ParentID: doc.ID,
+		Chunk:    number,
+		Lodestone: model.DocLodestone{
+			ProcessorVersion:   doc.Lodestone.ProcessorVersion,
+			Title:              doc.Lodestone.Title,
+			TitleSource:        doc.Lodestone.TitleSource,
+			Tags:               doc.Lodestone.Tags,
+			Category:           doc.Lodestone.Category,
+			CategorySource:     doc.Lodestone.CategorySource,
+			CategoryConfidence: doc.Lodestone.CategoryConfidence,
+			AutoKeywords:       doc.Lodestone.AutoKeywords,
+		},
+		File:    file,
+		Storage: doc.Storage,
+		Meta: model.DocMeta{
+			Title:    doc.Meta.Title,
+			Language: doc.Meta.Language,
+		},
+	}
+}
+
+// truncateText returns the first maxChars characters of text, and whether the text was truncated. A maxChars of 0
+// disables truncation.
+func truncateText(text string, maxChars int) (string, bool) {
+	if maxChars <= 0 || len(text) <= maxChars {
+		//a string cannot contain more characters than bytes
+		return text, false
+	}
+
+	count := 0
+	for i := range text {
+		if count == maxChars {
+			return text[:i], true
+		}
+		count++
+	}
+	return text, false
+}
+
+// limitPages drops the pages past maxIndexedPages. The pages of single page documents are dropped too, the page content
+// would just be a second copy of the document content.
+func limitPages(pages []model.DocPage) []model.DocPage {
+	if len(pages) == 1 {
+		return nil
+	}
+	if len(pages) > maxIndexedPages {
+		return pages[:maxIndexedPages]
+	}
+	return pages
+}
+
+// truncatePages drops (or truncates) the pages that extend past the first maxChars characters of page content
+func truncatePages(pages []model.DocPage, maxChars int) []model.DocPage {
+	if maxChars <= 0 || len(pages) == 0 {
+		return pages
+	}
+
+	truncated := []model.DocPage{}
+	remaining := maxChars
+	for _, page := range pages {
+		if remaining <= 0 {
+			break
+		}
+		page.Content, _ = truncateText(page.Content, remaining)
+		remaining -= utf8.RuneCountInString(page.Content)
+		truncated = append(truncated, page)
+	}
+	return truncated
+}
+
+// splitChunks splits text into chunks of at most size characters. Where possible chunks end on whitespace, so that
+// words are not split across chunks.
+func splitChunks(text string, size int) []string {
+	chunks := []string{}
+	for text != "" {
+		chunk, truncated := truncateText(text, size)
+		if truncated {
+			//only look for a word boundary in the last 20% of the chunk, very long "words" are split anyway
+			minEnd := len(chunk) - len(chunk)/5
+			if end := strings.LastIndexFunc(chunk, unicode.IsSpace); end > 0 && end >= minEnd {
+				chunk = chunk[:end]
+			}
+		}
+		text = strings.TrimLeftFunc(text[len(chunk):], unicode.IsSpace)
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
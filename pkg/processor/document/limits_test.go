@@ -0,0 +1,146 @@
+package document
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateText(t *testing.T) {
+	var tests = []struct {
+		text          string
+		maxChars      int
+		expected      string
+		expectedTrunc bool
+	}{
+		{"hello world", 0, "hello world", false},
+		{"hello world", 20, "hello world", false},
+		{"hello world", 11, "hello world", false},
+		{"hello world", 5, "hello", true},
+		{"größe", 3, "grö", true}, //multi-byte characters are not split
+		{"", 3, "", false},
+	}
+
+	for _, tt := range tests {
+		actual, truncated := truncateText(tt.text, tt.maxChars)
+		require.Equal(t, tt.expected, actual, "text: %q", tt.text)
+		require.Equal(t, tt.expectedTrunc, truncated, "text: %q", tt.text)
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	require.Equal(t, []string{"the quick", "brown fox", "jumps over", "the lazy", "dog"}, splitChunks("the quick brown fox jumps over the lazy dog", 10))
+	require.Equal(t, []string{"abcd", "efgh", "ij"}, splitChunks("abcdefghij", 4), "long words are split")
+	require.Equal(t, []string{}, splitChunks("", 4))
+}
+
+func TestLimitPages(t *testing.T) {
+	pages := make([]model.DocPage, maxIndexedPages+1)
+	for i := range pages {
+		pages[i] = model.DocPage{Number: i + 1, Content: "page"}
+	}
+
+	require.Len(t, limitPages(pages), maxIndexedPages)
+	require.Equal(t, pages[:2], limitPages(pages[:2]))
+	require.Nil(t, limitPages(pages[:1]), "single pages duplicate the content")
+	require.Nil(t, limitPages(nil))
+}
+
+func TestDocumentProcessor_ApplyContentLimits(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{
+		contentLimits: ContentLimits{MaxIndexedChars: 12, ChunkSize: 12},
+		logger:        logrus.WithField("test", t.Name()),
+	}
+	doc := model.Document{
+		ID:      "parent",
+		Content: "first page\n\nsecond page",
+		Pages:   []model.DocPage{{Number: 1, Content: "first page"}, {Number: 2, Content: "second page"}},
+		Lodestone: model.DocLodestone{
+			Tags: []string{"taxes"},
+		},
+		Storage: model.DocStorage{Bucket: "documents", Path: "taxes/report.pdf"},
+	}
+
+	//test
+	docs := proc.applyContentLimits(doc)
+
+	//assert
+	require.Len(t, docs, 3)
+	require.Equal(t, "first page\n\n", docs[0].Content)
+	require.True(t, docs[0].File.Truncated)
+	require.Equal(t, int64(12), docs[0].File.IndexedChars)
+	require.Equal(t, []model.DocPage{{Number: 1, Content: "first page"}, {Number: 2, Content: "se"}}, docs[0].Pages)
+
+	for i, chunk := range docs[1:] {
+		require.Equal(t, "parent", chunk.ParentID)
+		require.Equal(t, i+1, chunk.Chunk)
+		require.Equal(t, []string{"taxes"}, chunk.Lodestone.Tags)
+		require.Equal(t, "taxes/report.pdf", chunk.Storage.Path)
+		require.False(t, chunk.File.Truncated)
+	}
+	require.Equal(t, "first page", docs[1].Content)
+	require.Equal(t, "second page", docs[2].Content)
+	require.NotEqual(t, docs[1].ID, docs[2].ID)
+}
+
+func TestDocumentProcessor_ApplyContentLimits_Disabled(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{logger: logrus.WithField("test", t.Name())}
+	doc := model.Document{ID: "parent", Content: strings.Repeat("lorem ipsum ", 1000)}
+
+	//test
+	docs := proc.applyContentLimits(doc)
+
+	//assert
+	require.Equal(t, []model.Document{doc}, docs)
+}
+
+func TestDocumentProcessor_ParseDocument_TikaMaxBytes(t *testing.T) {
+	//setup
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "%PDF-1.4", string(body))
+
+		//tika cannot parse the truncated pdf
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "large.pdf")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("%PDF-1.4 a very large pdf document"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:            newTestTikaPool(t, tikaServer.URL),
+		metadataMapping: metadataMapping,
+		contentLimits:   ContentLimits{TikaMaxBytes: 8},
+		logger:          logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "large.pdf", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	require.Equal(t, "", docs[0].Content)
+	require.True(t, docs[0].File.Truncated)
+	require.Equal(t, int64(34), docs[0].File.Filesize)
+	require.Equal(t, "large.pdf", docs[0].File.FileName)
+}
@@ -0,0 +1,136 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tika metadata is returned as JSON, where each key maps to either a single string, or an array of strings
+// (when the key is multi-valued, eg. "dc:format" or a list of keywords). After json.Unmarshal into a
+// map[string]interface{} these are `string` and `[]interface{}` values. Numbers and booleans are not
+// returned by Tika, but are handled in case a parser (or a metadata mapping) produces them.
+
+// layouts used to parse metadata dates, in order of preference. Layouts without a timezone are assumed to be UTC.
+var metadataTimeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999", //tika dates without a zone
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006:01:02 15:04:05Z07:00", //exif dates
+	"2006:01:02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+	"2006-01-02",
+	"2006:01:02",
+	"2006/01/02",
+	"2006-01",
+	"2006",
+}
+
+// metadataValues decodes a tika metadata value into a list of non-empty strings.
+func metadataValues(val interface{}) []string {
+	switch typedVal := val.(type) {
+	case nil:
+		return nil
+	case string:
+		typedVal = strings.TrimSpace(typedVal)
+		if typedVal == "" {
+			return nil
+		}
+		return []string{typedVal}
+	case []string:
+		var values []string
+		for _, item := range typedVal {
+			values = append(values, metadataValues(item)...)
+		}
+		return values
+	case []interface{}:
+		var values []string
+		for _, item := range typedVal {
+			values = append(values, metadataValues(item)...)
+		}
+		return values
+	case float64:
+		return []string{strconv.FormatFloat(typedVal, 'f', -1, 64)}
+	case int:
+		return []string{strconv.Itoa(typedVal)}
+	case int64:
+		return []string{strconv.FormatInt(typedVal, 10)}
+	case bool:
+		return []string{strconv.FormatBool(typedVal)}
+	default:
+		return nil
+	}
+}
+
+// castToString joins multi-valued metadata into a single comma separated string.
+func castToString(val interface{}) string {
+	return strings.Join(metadataValues(val), ", ")
+}
+
+// castToStringArray returns nil if the metadata value is missing or empty.
+func castToStringArray(val interface{}) []string {
+	return metadataValues(val)
+}
+
+// castToTime returns the first value that can be parsed as a date, or a zero time.
+func castToTime(val interface{}) time.Time {
+	for _, str := range metadataValues(val) {
+		if t, ok := parseMetadataTime(str); ok {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parseMetadataTime(str string) (time.Time, bool) {
+	for _, layout := range metadataTimeLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// castToInteger returns the first value that can be parsed as an integer. Whole floating point values (eg. "4.0") are
+// accepted as well.
+func castToInteger(val interface{}) (int64, bool) {
+	for _, str := range metadataValues(val) {
+		if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return i, true
+		}
+		if f, err := strconv.ParseFloat(str, 64); err == nil && f == float64(int64(f)) {
+			return int64(f), true
+		}
+	}
+	return 0, false
+}
+
+// castToFloat returns the first value that can be parsed as a floating point number.
+func castToFloat(val interface{}) (float64, bool) {
+	for _, str := range metadataValues(val) {
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// castToBool returns the first value that can be parsed as a boolean (true/false, yes/no, 1/0)
+func castToBool(val interface{}) (bool, bool) {
+	for _, str := range metadataValues(val) {
+		switch strings.ToLower(str) {
+		case "true", "yes", "1":
+			return true, true
+		case "false", "no", "0":
+			return false, true
+		}
+	}
+	return false, false
+}
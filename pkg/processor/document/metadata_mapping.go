@@ -0,0 +1,281 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/markbates/pkger"
+	"gopkg.in/yaml.v2"
+)
+
+// metadata field types, used to coerce Tika metadata values.
+const (
+	metadataTypeString  = "string"
+	metadataTypeStrings = "strings"
+	metadataTypeDate    = "date"
+	metadataTypeInteger = "integer"
+	metadataTypeFloat   = "float"
+	metadataTypeBoolean = "boolean"
+)
+
+// special target field, stored in the File section of the document rather than in Meta
+const metadataFieldContentType = "content_type"
+
+// metadataMapping maps ordered lists of Tika metadata keys onto document fields. The first key with a (valid) value wins.
+// The default mapping is stored in static/document-processor/metadata_mapping.json, and can be overridden using a
+// JSON or YAML file.
+type metadataMapping struct {
+	// Fields maps onto the (json) field names of model.DocMeta, eg. "author" or "created". The type of these fields is
+	// determined by the DocMeta struct.
+	Fields map[string]metadataFieldMapping `json:"fields" yaml:"fields"`
+
+	// Custom fields are stored under meta.custom, and are coerced to the specified type (defaults to "string").
+	Custom map[string]metadataFieldMapping `json:"custom" yaml:"custom"`
+}
+
+type metadataFieldMapping struct {
+	Keys []string `json:"keys" yaml:"keys"`
+	Type string   `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+func loadMetadataMapping(mappingPath string) (*metadataMapping, error) {
+	var mappingFile io.ReadCloser
+	var err error
+
+	if mappingPath == "" {
+		mappingFile, err = pkger.Open("/static/document-processor/metadata_mapping.json")
+	} else {
+		mappingFile, err = os.Open(mappingPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open metadata mapping file: %v", err)
+	}
+	defer mappingFile.Close()
+
+	mappingData, err := ioutil.ReadAll(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping metadataMapping
+	switch strings.ToLower(filepath.Ext(mappingPath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(mappingData, &mapping)
+	default:
+		err = json.Unmarshal(mappingData, &mapping)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse metadata mapping file: %v", err)
+	}
+
+	return &mapping, mapping.validate()
+}
+
+func (m *metadataMapping) validate() error {
+	docMetaTypes := docMetaFieldTypes()
+	for fieldName, fieldMapping := range m.Fields {
+		fieldType, found := docMetaTypes[fieldName]
+		if !found {
+			return fmt.Errorf("metadata mapping: unknown field %q, custom fields must be specified in the `custom` section", fieldName)
+		}
+		if fieldMapping.Type != "" && fieldMapping.Type != fieldType {
+			return fmt.Errorf("metadata mapping: field %q must have type %q", fieldName, fieldType)
+		}
+		if len(fieldMapping.Keys) == 0 {
+			return fmt.Errorf("metadata mapping: field %q has no keys", fieldName)
+		}
+	}
+
+	for fieldName, fieldMapping := range m.Custom {
+		if fieldName == "" || normalizeMetadataKey(fieldName) != fieldName {
+			return fmt.Errorf("metadata mapping: invalid custom field name %q", fieldName)
+		}
+		switch fieldMapping.Type {
+		case "", metadataTypeString, metadataTypeStrings, metadataTypeDate, metadataTypeInteger, metadataTypeFloat, metadataTypeBoolean:
+		default:
+			return fmt.Errorf("metadata mapping: custom field %q has unknown type %q", fieldName, fieldMapping.Type)
+		}
+		if len(fieldMapping.Keys) == 0 {
+			return fmt.Errorf("metadata mapping: custom field %q has no keys", fieldName)
+		}
+	}
+	return nil
+}
+
+// apply populates the document metadata (and content type) using the parsed Tika metadata.
+func (m *metadataMapping) apply(parsedMeta map[string]interface{}, doc *model.Document) {
+	doc.Meta = model.DocMeta{}
+
+	if contentType, found := m.Fields[metadataFieldContentType]; found {
+		doc.File.ContentType, _ = findMetadata(parsedMeta, metadataTypeString, contentType.Keys...).(string)
+	}
+
+	metaValue := reflect.ValueOf(&doc.Meta).Elem()
+	for i := 0; i < metaValue.NumField(); i++ {
+		fieldName, fieldType := docMetaField(metaValue.Type().Field(i))
+		if fieldType == "" {
+			continue
+		}
+
+		var val interface{}
+		if fieldMapping, found := m.Fields[fieldName]; found {
+			val = findMetadata(parsedMeta, fieldType, fieldMapping.Keys...)
+		}
+
+		switch typedVal := val.(type) {
+		case string:
+			metaValue.Field(i).SetString(typedVal)
+		case []string:
+			metaValue.Field(i).Set(reflect.ValueOf(typedVal))
+		case time.Time:
+			metaValue.Field(i).Set(reflect.ValueOf(typedVal))
+		case int64:
+			field := metaValue.Field(i)
+			if field.Kind() == reflect.Int && !field.OverflowInt(typedVal) {
+				field.SetInt(typedVal)
+			} else if field.Kind() == reflect.Uint8 && typedVal >= 0 && !field.OverflowUint(uint64(typedVal)) {
+				field.SetUint(uint64(typedVal))
+			}
+		case nil:
+			if fieldType == metadataTypeStrings {
+				//keep existing behavior, missing keywords are stored as an empty list
+				metaValue.Field(i).Set(reflect.ValueOf([]string{}))
+			}
+		}
+	}
+
+	for fieldName, fieldMapping := range m.Custom {
+		fieldType := fieldMapping.Type
+		if fieldType == "" {
+			fieldType = metadataTypeString
+		}
+
+		val := findMetadata(parsedMeta, fieldType, fieldMapping.Keys...)
+		if val == nil {
+			continue
+		}
+		if doc.Meta.Custom == nil {
+			doc.Meta.Custom = map[string]interface{}{}
+		}
+		doc.Meta.Custom[fieldName] = val
+	}
+}
+
+// elasticsearch field types of the custom field types
+var metadataIndexTypes = map[string]string{
+	metadataTypeString:  "keyword",
+	metadataTypeStrings: "keyword",
+	metadataTypeDate:    "date",
+	metadataTypeInteger: "long",
+	metadataTypeFloat:   "double",
+	metadataTypeBoolean: "boolean",
+}
+
+// addIndexMappings adds the mappings of the custom fields to the index settings, so the type of a custom field is
+// determined by the mapping file rather than by the first document containing it.
+func (m *metadataMapping) addIndexMappings(indexSettings map[string]interface{}) {
+	if m == nil || len(m.Custom) == 0 {
+		return
+	}
+
+	customProperties := map[string]interface{}{}
+	for fieldName, fieldMapping := range m.Custom {
+		fieldType := fieldMapping.Type
+		if fieldType == "" {
+			fieldType = metadataTypeString
+		}
+		customProperties[fieldName] = map[string]interface{}{"type": metadataIndexTypes[fieldType]}
+	}
+
+	metaProperties := nestedSettingsMap(indexSettings, "mappings", "properties", "meta", "properties")
+	metaProperties["custom"] = map[string]interface{}{"properties": customProperties}
+}
+
+// nestedSettingsMap returns the map at the path, creating any missing maps
+func nestedSettingsMap(settings map[string]interface{}, path ...string) map[string]interface{} {
+	for _, key := range path {
+		child, ok := settings[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			settings[key] = child
+		}
+		settings = child
+	}
+	return settings
+}
+
+// findMetadata returns the first value (coerced to the specified type) found for the keys, or nil if no key has a value.
+func findMetadata(dict map[string]interface{}, fieldType string, keys ...string) interface{} {
+	for _, key := range keys {
+		val := dict[key]
+		switch fieldType {
+		case metadataTypeString:
+			if str := castToString(val); str != "" {
+				return str
+			}
+		case metadataTypeStrings:
+			if strs := castToStringArray(val); len(strs) > 0 {
+				return strs
+			}
+		case metadataTypeDate:
+			if t := castToTime(val); !t.IsZero() {
+				return t
+			}
+		case metadataTypeInteger:
+			if i, ok := castToInteger(val); ok {
+				return i
+			}
+		case metadataTypeFloat:
+			if f, ok := castToFloat(val); ok {
+				return f
+			}
+		case metadataTypeBoolean:
+			if b, ok := castToBool(val); ok {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// docMetaFieldTypes returns the metadata type of every mappable field, keyed by field name.
+func docMetaFieldTypes() map[string]string {
+	fieldTypes := map[string]string{
+		metadataFieldContentType: metadataTypeString,
+	}
+	metaType := reflect.TypeOf(model.DocMeta{})
+	for i := 0; i < metaType.NumField(); i++ {
+		fieldName, fieldType := docMetaField(metaType.Field(i))
+		if fieldType != "" {
+			fieldTypes[fieldName] = fieldType
+		}
+	}
+	return fieldTypes
+}
+
+// docMetaField returns the (json) name and metadata type of a DocMeta struct field. The type is empty if the field
+// cannot be populated via the mapping (eg. the raw and custom maps)
+func docMetaField(field reflect.StructField) (string, string) {
+	fieldName := strings.Split(field.Tag.Get("json"), ",")[0]
+
+	switch field.Type {
+	case reflect.TypeOf(""):
+		return fieldName, metadataTypeString
+	case reflect.TypeOf([]string{}):
+		return fieldName, metadataTypeStrings
+	case reflect.TypeOf(time.Time{}):
+		return fieldName, metadataTypeDate
+	case reflect.TypeOf(byte(0)), reflect.TypeOf(0):
+		return fieldName, metadataTypeInteger
+	default:
+		return fieldName, ""
+	}
+}
@@ -0,0 +1,116 @@
+package document
+
+import (
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeMetadataMapping(t *testing.T, pattern string, content string) string {
+	mappingFile, err := ioutil.TempFile("", pattern)
+	require.NoError(t, err)
+	defer mappingFile.Close()
+
+	_, err = mappingFile.WriteString(content)
+	require.NoError(t, err)
+	return mappingFile.Name()
+}
+
+func TestLoadMetadataMapping_Yaml(t *testing.T) {
+
+	//setup
+	mappingPath := writeMetadataMapping(t, "mapping*.yaml", `
+fields:
+  title:
+    keys: ["dc:title", "title"]
+  created:
+    keys: ["exif:DateTimeOriginal", "created"]
+  keywords:
+    keys: ["dc:subject"]
+  rating:
+    keys: ["xmp:Rating"]
+custom:
+  page_count:
+    keys: ["xmpTPg:NPages", "meta:page-count"]
+    type: integer
+  encrypted:
+    keys: ["pdf:encrypted"]
+    type: boolean
+  gps_altitude:
+    keys: ["GPS Altitude"]
+    type: float
+  camera:
+    keys: ["tiff:Model"]
+`)
+	defer os.Remove(mappingPath)
+
+	//test
+	mapping, err := loadMetadataMapping(mappingPath)
+	require.NoError(t, err)
+
+	doc := model.Document{}
+	mapping.apply(map[string]interface{}{
+		"title":                 "Fallback Title",
+		"exif:DateTimeOriginal": "2019:07:04 12:30:00",
+		"dc:subject":            []interface{}{"vacation", "beach"},
+		"xmp:Rating":            "4",
+		"meta:page-count":       "12",
+		"pdf:encrypted":         "false",
+		"GPS Altitude":          "12.5",
+		"Content-Type":          "image/jpeg",
+	}, &doc)
+
+	//assert
+	require.Equal(t, "", doc.File.ContentType, "content_type is not part of the override mapping")
+	require.Equal(t, "Fallback Title", doc.Meta.Title)
+	require.Equal(t, time.Date(2019, time.July, 4, 12, 30, 0, 0, time.UTC), doc.Meta.CreatedDate)
+	require.Equal(t, []string{"vacation", "beach"}, doc.Meta.Keywords)
+	require.Equal(t, byte(4), doc.Meta.Rating)
+	require.Equal(t, "", doc.Meta.Author)
+	require.Equal(t, map[string]interface{}{
+		"page_count":   int64(12),
+		"encrypted":    false,
+		"gps_altitude": 12.5,
+	}, doc.Meta.Custom)
+
+	//custom fields are mapped with their declared types
+	indexSettings := map[string]interface{}{"mappings": map[string]interface{}{"properties": map[string]interface{}{
+		"meta": map[string]interface{}{"properties": map[string]interface{}{"title": map[string]interface{}{"type": "text"}}},
+	}}}
+	mapping.addIndexMappings(indexSettings)
+	require.Equal(t, map[string]interface{}{
+		"title": map[string]interface{}{"type": "text"},
+		"custom": map[string]interface{}{"properties": map[string]interface{}{
+			"page_count":   map[string]interface{}{"type": "long"},
+			"encrypted":    map[string]interface{}{"type": "boolean"},
+			"gps_altitude": map[string]interface{}{"type": "double"},
+			"camera":       map[string]interface{}{"type": "keyword"},
+		}},
+	}, indexSettings["mappings"].(map[string]interface{})["properties"].(map[string]interface{})["meta"].(map[string]interface{})["properties"])
+}
+
+func TestLoadMetadataMapping_Invalid(t *testing.T) {
+	var tests = []struct {
+		mapping string
+		err     string
+	}{
+		{`{"fields": {"unknown": {"keys": ["a"]}}}`, `unknown field "unknown"`},
+		{`{"fields": {"created": {"keys": ["a"], "type": "string"}}}`, `field "created" must have type "date"`},
+		{`{"fields": {"title": {"keys": []}}}`, `field "title" has no keys`},
+		{`{"custom": {"page count": {"keys": ["a"]}}}`, `invalid custom field name "page count"`},
+		{`{"custom": {"pages": {"keys": ["a"], "type": "long"}}}`, `unknown type "long"`},
+		{`{"fields": `, `could not parse metadata mapping file`},
+	}
+
+	for _, tt := range tests {
+		mappingPath := writeMetadataMapping(t, "mapping*.json", tt.mapping)
+		_, err := loadMetadataMapping(mappingPath)
+		os.Remove(mappingPath)
+
+		require.Error(t, err, tt.mapping)
+		require.Contains(t, err.Error(), tt.err)
+	}
+}
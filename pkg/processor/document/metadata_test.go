@@ -0,0 +1,95 @@
+package document
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// decode a metadata value the same way parseTikaMetadata does
+func decodeMetadataValue(t *testing.T, valJson string) interface{} {
+	var val interface{}
+	require.NoError(t, json.Unmarshal([]byte(valJson), &val))
+	return val
+}
+
+func TestCastToString(t *testing.T) {
+	var tests = []struct {
+		valJson  string
+		expected string
+	}{
+		{`null`, ""},
+		{`""`, ""},
+		{`"  "`, ""},
+		{`"2018 Form 4868"`, "2018 Form 4868"},
+		{`["application/pdf; version=1.7", "application/pdf; version=\"1.7 Adobe Extension Level 5\""]`, `application/pdf; version=1.7, application/pdf; version="1.7 Adobe Extension Level 5"`},
+		{`["", "single"]`, "single"},
+		{`[]`, ""},
+		{`4`, "4"},
+		{`1.5`, "1.5"},
+		{`true`, "true"},
+		{`{"nested": "object"}`, ""},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, castToString(decodeMetadataValue(t, tt.valJson)), tt.valJson)
+	}
+}
+
+func TestCastToStringArray(t *testing.T) {
+	var tests = []struct {
+		valJson  string
+		expected []string
+	}{
+		{`null`, nil},
+		{`""`, nil},
+		{`"Fillable"`, []string{"Fillable"}},
+		{`["tax", "2018", ""]`, []string{"tax", "2018"}},
+		{`[["nested"], "flat"]`, []string{"nested", "flat"}},
+		{`[]`, nil},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, castToStringArray(decodeMetadataValue(t, tt.valJson)), tt.valJson)
+	}
+
+	//values which were not decoded from json
+	require.Equal(t, []string{"a", "b"}, castToStringArray([]string{"a", "", "b"}))
+}
+
+func TestCastToTime(t *testing.T) {
+	pst := time.FixedZone("", -8*60*60)
+
+	var tests = []struct {
+		valJson  string
+		expected time.Time
+	}{
+		{`null`, time.Time{}},
+		{`""`, time.Time{}},
+		{`"not a date"`, time.Time{}},
+		{`"2018-10-24T18:27:15Z"`, time.Date(2018, time.October, 24, 18, 27, 15, 0, time.UTC)},
+		{`"2018-10-24T18:27:15.123Z"`, time.Date(2018, time.October, 24, 18, 27, 15, 123000000, time.UTC)},
+		{`"2018-10-24T10:27:15-08:00"`, time.Date(2018, time.October, 24, 10, 27, 15, 0, pst)},
+		{`"2018-10-24T10:27:15-0800"`, time.Date(2018, time.October, 24, 10, 27, 15, 0, pst)},
+		{`"2018-10-24T18:27Z"`, time.Date(2018, time.October, 24, 18, 27, 0, 0, time.UTC)},
+		{`"2018-10-24T18:27:15"`, time.Date(2018, time.October, 24, 18, 27, 15, 0, time.UTC)}, //tika dates without a zone
+		{`"2018-10-24T18:27:15.5"`, time.Date(2018, time.October, 24, 18, 27, 15, 500000000, time.UTC)},
+		{`"2018-10-24 18:27:15"`, time.Date(2018, time.October, 24, 18, 27, 15, 0, time.UTC)},
+		{`"2018:10:24 18:27:15"`, time.Date(2018, time.October, 24, 18, 27, 15, 0, time.UTC)}, //exif
+		{`"2018:10:24 10:27:15-08:00"`, time.Date(2018, time.October, 24, 10, 27, 15, 0, pst)},
+		{`"Wed, 24 Oct 2018 10:27:15 -0800"`, time.Date(2018, time.October, 24, 10, 27, 15, 0, pst)},
+		{`"2018-10-24"`, time.Date(2018, time.October, 24, 0, 0, 0, 0, time.UTC)},
+		{`"2018:10:24"`, time.Date(2018, time.October, 24, 0, 0, 0, 0, time.UTC)},
+		{`"2018/10/24"`, time.Date(2018, time.October, 24, 0, 0, 0, 0, time.UTC)},
+		{`"2018-10"`, time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		{`"2018"`, time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{`["2018-10-24T18:27:15Z", "2019-01-01T00:00:00Z"]`, time.Date(2018, time.October, 24, 18, 27, 15, 0, time.UTC)},
+		{`["garbage", "2019-01-01"]`, time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		actual := castToTime(decodeMetadataValue(t, tt.valJson))
+		require.True(t, tt.expected.Equal(actual), "%s: expected %v, got %v", tt.valJson, tt.expected, actual)
+	}
+}
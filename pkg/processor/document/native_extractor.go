@@ -0,0 +1,320 @@
+package document
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-tika/tika"
+	htmlparser "golang.org/x/net/html"
+)
+
+// formats supported by the native extractor
+const (
+	nativeFormatText     = "text/plain"
+	nativeFormatMarkdown = "text/markdown"
+	nativeFormatCsv      = "text/csv"
+	nativeFormatTsv      = "text/tab-separated-values"
+	nativeFormatJson     = "application/json"
+	nativeFormatHtml     = "text/html"
+	nativeFormatXml      = "application/xml"
+)
+
+var nativeFormatsByExtension = map[string]string{
+	"txt":      nativeFormatText,
+	"text":     nativeFormatText,
+	"log":      nativeFormatText,
+	"md":       nativeFormatMarkdown,
+	"markdown": nativeFormatMarkdown,
+	"csv":      nativeFormatCsv,
+	"tsv":      nativeFormatTsv,
+	"json":     nativeFormatJson,
+	"html":     nativeFormatHtml,
+	"htm":      nativeFormatHtml,
+	"xhtml":    nativeFormatHtml,
+	"xml":      nativeFormatXml,
+}
+
+var nativeFormatsByContentType = map[string]string{
+	"text/plain":                nativeFormatText,
+	"text/markdown":             nativeFormatMarkdown,
+	"text/x-markdown":           nativeFormatMarkdown,
+	"text/csv":                  nativeFormatCsv,
+	"text/tab-separated-values": nativeFormatTsv,
+	"application/json":          nativeFormatJson,
+	"text/html":                 nativeFormatHtml,
+	"application/xhtml+xml":     nativeFormatHtml,
+	"application/xml":           nativeFormatXml,
+	"text/xml":                  nativeFormatXml,
+}
+
+// html elements whose content should not be indexed, the title is extracted separately from the head element
+var htmlIgnoredElements = map[string]bool{"script": true, "style": true, "noscript": true, "template": true}
+
+// maximum number of bytes of a file read by the native extractor when ContentLimits.TikaMaxBytes is not set, files are
+// read into memory
+const nativeExtractionMaxBytes = 64 << 20
+
+// nativeExtractor extracts simple text formats without Tika. Text is converted to UTF-8 (see decodeText), markup is
+// removed from HTML & XML documents, and CSV & JSON documents are flattened to one record/value per line.
+type nativeExtractor struct {
+	maxBytes int64
+}
+
+func (ne *nativeExtractor) Name() string {
+	return extractionMethodNative
+}
+
+func (ne *nativeExtractor) Supports(fileName string, contentType string) bool {
+	return nativeFormat(fileName, contentType) != ""
+}
+
+func nativeFormat(fileName string, contentType string) string {
+	if format, ok := nativeFormatsByExtension[strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))]; ok {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return nativeFormatsByContentType[mediaType]
+}
+
+func (ne *nativeExtractor) Extract(bucketPath string, source *documentSource, ocr ocrSettings) ([]map[string][]string, error) {
+	format := nativeFormat(bucketPath, detectContentType(bucketPath, source.head))
+	if format == "" {
+		return nil, fmt.Errorf("unsupported format for native extraction: %s", bucketPath)
+	}
+
+	docFile, err := source.open()
+	if err != nil {
+		return nil, err
+	}
+	defer docFile.Close()
+
+	//one more byte is read to detect truncated files
+	maxBytes := ne.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = nativeExtractionMaxBytes
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(docFile, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	truncated := int64(len(data)) > maxBytes
+	if truncated {
+		data = data[:maxBytes]
+	}
+
+	text, charset := decodeText(data, truncated)
+	title := ""
+	content := text
+	switch format {
+	case nativeFormatMarkdown:
+		title = markdownTitle(text)
+	case nativeFormatCsv:
+		content = csvText(text, ',')
+	case nativeFormatTsv:
+		content = csvText(text, '\t')
+	case nativeFormatJson:
+		content = jsonText(text)
+	case nativeFormatHtml:
+		content, title = htmlText(text)
+	case nativeFormatXml:
+		content = xmlText(text)
+	}
+
+	resource := map[string][]string{
+		tika.XTIKAContent:  {nativeXhtml(title, content)},
+		"Content-Type":     {format + "; charset=" + charset},
+		"Content-Encoding": {charset},
+		"X-Parsed-By":      {"lodestone-native"},
+	}
+	if title != "" {
+		resource["dc:title"] = []string{title}
+	}
+	return []map[string][]string{resource}, nil
+}
+
+// nativeXhtml wraps plain text in an XHTML document, matching the content returned by Tika
+func nativeXhtml(title string, content string) string {
+	return fmt.Sprintf(`<html xmlns="http://www.w3.org/1999/xhtml"><head><title>%s</title></head><body><pre>%s</pre></body></html>`,
+		html.EscapeString(title), html.EscapeString(content))
+}
+
+// markdownTitle returns the first level 1 heading of a markdown document
+func markdownTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "# "))
+		}
+	}
+	return ""
+}
+
+// csvText converts every record to a single line. Malformed documents are returned as-is.
+func csvText(text string, delimiter rune) string {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return text
+	}
+
+	lines := []string{}
+	for _, record := range records {
+		lines = append(lines, strings.Join(deleteEmpty(record), " | "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jsonText converts every value to a "key: value" line. Malformed documents are returned as-is.
+func jsonText(text string) string {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return text
+	}
+
+	lines := []string{}
+	var walk func(key string, value interface{})
+	walk = func(key string, value interface{}) {
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(typedValue))
+			for childKey := range typedValue {
+				keys = append(keys, childKey)
+			}
+			sort.Strings(keys)
+			for _, childKey := range keys {
+				walk(childKey, typedValue[childKey])
+			}
+		case []interface{}:
+			for _, item := range typedValue {
+				walk(key, item)
+			}
+		case nil:
+		default:
+			line := fmt.Sprintf("%v", typedValue)
+			if key != "" {
+				line = key + ": " + line
+			}
+			lines = append(lines, line)
+		}
+	}
+	walk("", value)
+	return strings.Join(lines, "\n")
+}
+
+// htmlText converts an HTML document to plain text, returning the text and the document title.
+func htmlText(text string) (string, string) {
+	tokenizer := htmlparser.NewTokenizer(strings.NewReader(text))
+
+	var content strings.Builder
+	var title strings.Builder
+	ignoredElement := "" //we are inside an element whose content should not be indexed
+	inTitle := false
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == htmlparser.ErrorToken {
+			//io.EOF, html tokenization never fails otherwise
+			break
+		}
+
+		token := tokenizer.Token()
+		switch tokenType {
+		case htmlparser.StartTagToken, htmlparser.SelfClosingTagToken:
+			if ignoredElement != "" {
+				continue
+			}
+			if token.Data == "title" {
+				inTitle = true
+			} else if tokenType == htmlparser.StartTagToken && htmlIgnoredElements[token.Data] {
+				ignoredElement = token.Data
+			} else if xhtmlBlockElements[token.Data] || token.Data == "br" || token.Data == "hr" {
+				content.WriteString("\n")
+			}
+
+		case htmlparser.EndTagToken:
+			if ignoredElement != "" {
+				if token.Data == ignoredElement {
+					ignoredElement = ""
+				}
+				continue
+			}
+			if token.Data == "title" {
+				inTitle = false
+			} else if xhtmlBlockElements[token.Data] {
+				content.WriteString("\n")
+			}
+
+		case htmlparser.TextToken:
+			if ignoredElement != "" {
+				continue
+			}
+			if inTitle {
+				title.WriteString(token.Data)
+			} else {
+				content.WriteString(collapseWhitespace(token.Data))
+			}
+		}
+	}
+
+	return normalizeText(content.String()), strings.Join(strings.Fields(title.String()), " ")
+}
+
+// collapseWhitespace replaces runs of whitespace (including newlines) with a single space, as browsers do.
+func collapseWhitespace(text string) string {
+	collapsed := strings.Join(strings.Fields(text), " ")
+	if collapsed == "" {
+		if text != "" {
+			return " "
+		}
+		return ""
+	}
+	if strings.TrimLeft(text, " \t\r\n") != text {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(text, " \t\r\n") != text {
+		collapsed = collapsed + " "
+	}
+	return collapsed
+}
+
+// xmlText returns the text of every XML element on a separate line. Malformed documents are converted up to the first
+// error.
+func xmlText(text string) string {
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	decoder.Strict = false
+	//the document has already been converted to UTF-8
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var content bytes.Buffer
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if charData, ok := token.(xml.CharData); ok {
+			if line := strings.TrimSpace(string(charData)); line != "" {
+				content.WriteString(line)
+				content.WriteString("\n")
+			}
+		}
+	}
+	return strings.TrimSpace(content.String())
+}
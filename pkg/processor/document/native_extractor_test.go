@@ -0,0 +1,277 @@
+package document
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeText(t *testing.T) {
+	var tests = []struct {
+		data            []byte
+		truncated       bool
+		expected        string
+		expectedCharset string
+	}{
+		{[]byte("Größe"), false, "Größe", charsetUTF8},
+		{[]byte("Größe €")[:len("Größe €")-1], true, "Größe ", charsetUTF8},
+		{[]byte("caf\xE9"), false, "café", charsetWindows1252},
+		{[]byte("\xEF\xBB\xBFGröße"), false, "Größe", charsetUTF8},
+		{[]byte("\xFF\xFEG\x00r\x00\xF6\x00\xDF\x00e\x00"), false, "Größe", charsetUTF16LE},
+		{[]byte("\x00G\x00r\x00\xF6\x00\xDF\x00e"), false, "Größe", charsetUTF16BE},
+		{[]byte("G\x00r\x00\xF6\x00\xDF\x00e\x00"), false, "Größe", charsetUTF16LE},
+		{[]byte(`<meta charset="iso-8859-1">Gr` + "\xF6\xDF" + "e \x80"), false, `<meta charset="iso-8859-1">Größe ` + "\u0080", charsetISO88591},
+		{[]byte("Gr\xF6\xDFe \x80 \x93quoted\x94"), false, "Größe € “quoted”", charsetWindows1252},
+	}
+
+	for _, tt := range tests {
+		actual, charset := decodeText(tt.data, tt.truncated)
+		require.Equal(t, tt.expected, actual, "data: %q", tt.data)
+		require.Equal(t, tt.expectedCharset, charset, "data: %q", tt.data)
+	}
+}
+
+func TestNativeFormat(t *testing.T) {
+	require.Equal(t, nativeFormatMarkdown, nativeFormat("notes/README.MD", ""))
+	require.Equal(t, nativeFormatCsv, nativeFormat("export.csv", "text/plain; charset=utf-8"))
+	require.Equal(t, nativeFormatText, nativeFormat("notes/todo", "text/plain; charset=utf-8"))
+	require.Equal(t, nativeFormatHtml, nativeFormat("page", "application/xhtml+xml"))
+	require.Equal(t, "", nativeFormat("report.pdf", "application/pdf"))
+}
+
+func TestHtmlText(t *testing.T) {
+	//test
+	content, title := htmlText(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>
+      Quarterly   Report
+    </title>
+    <style>body { color: red; }</style>
+  </head>
+  <body>
+    <h1>Revenue &amp; Costs</h1>
+    <p>Revenue grew
+       by <b>12%</b>.<br>Costs did not.</p>
+    <script>alert("ignored")</script>
+    <ul><li>one</li><li>two</li></ul>
+  </body>
+</html>`)
+
+	//assert
+	require.Equal(t, "Quarterly Report", title)
+	require.Equal(t, "Revenue & Costs\n\nRevenue grew by 12%.\nCosts did not.\n\none\n\ntwo", content)
+}
+
+func TestCsvText(t *testing.T) {
+	require.Equal(t, "name | amount\nrent | 1200\nunterminated", csvText("name,amount\nrent,1200\n\"unterminated", ','))
+	require.Equal(t, "name | amount\nrent | 1200", csvText("name\tamount\nrent\t1200\n", '\t'))
+}
+
+func TestJsonText(t *testing.T) {
+	require.Equal(t, "amount: 12.50\nname: rent\ntags: home\ntags: monthly", jsonText(`{"name": "rent", "amount": 12.50, "tags": ["home", "monthly"], "notes": null}`))
+	require.Equal(t, "{not json", jsonText("{not json"))
+}
+
+func TestXmlText(t *testing.T) {
+	require.Equal(t, "Lodestone\nDocument processor", xmlText(`<?xml version="1.0" encoding="ISO-8859-1"?><project><name>Lodestone</name><description> Document processor </description></project>`))
+}
+
+func TestMarkdownTitle(t *testing.T) {
+	require.Equal(t, "Lodestone", markdownTitle("Intro\n# Lodestone\n## Install\n"))
+	require.Equal(t, "", markdownTitle("## Install\n"))
+}
+
+func TestDocumentProcessor_ParseDocument_NativeExtraction(t *testing.T) {
+	//setup
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected tika request: %s", r.URL.Path)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "README.md")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("# Lodestone\n\nA <searchable> document store & processor."), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:             newTestTikaPool(t, tikaServer.URL),
+		nativeExtraction: true,
+		metadataMapping:  metadataMapping,
+		logger:           logrus.WithField("test", t.Name()),
+	}
+	require.False(t, proc.requiresTika("docs/README.md", ""))
+	require.True(t, proc.requiresTika("docs/report.pdf", ""))
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	source.attributes = model.FileAttributes{Owner: "jason", Group: "staff", Mode: 0640}
+	docs, err := proc.parseDocument("documents", "docs/README.md", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	require.Equal(t, "# Lodestone\n\nA <searchable> document store & processor.", docs[0].Content)
+	require.Equal(t, extractionMethodNative, docs[0].File.ExtractionMethod)
+	require.Equal(t, "text/markdown; charset=UTF-8", docs[0].File.ContentType)
+	require.Equal(t, "Lodestone", docs[0].Meta.Title)
+	require.Equal(t, "jason", docs[0].File.Owner)
+	require.Equal(t, "staff", docs[0].File.Group)
+	require.Equal(t, "0640", docs[0].File.Mode)
+}
+
+func TestDocumentProcessor_ParseDocument_NativeExtractionTruncated(t *testing.T) {
+	//setup
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "notes.txt")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("naïve café"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:             newTestTikaPool(t, "http://127.0.0.1:1"),
+		nativeExtraction: true,
+		metadataMapping:  metadataMapping,
+		contentLimits:    ContentLimits{TikaMaxBytes: 3},
+		logger:           logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "notes.txt", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	require.Equal(t, "na", docs[0].Content, "the incomplete ï is removed")
+	require.Equal(t, "text/plain; charset=UTF-8", docs[0].File.ContentType)
+	require.True(t, docs[0].File.Truncated)
+}
+
+func TestDocumentProcessor_ParseDocument_NativeExtractionTruncatedDownload(t *testing.T) {
+	//setup
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("lodestone ", 1000)
+
+	//the rest of the file is still being downloaded when the extractor has read enough
+	body, bodyWriter := io.Pipe()
+	go func() {
+		bodyWriter.Write([]byte(content[:2000]))
+		time.Sleep(50 * time.Millisecond)
+		bodyWriter.Write([]byte(content[2000:]))
+		bodyWriter.Close()
+	}()
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:             newTestTikaPool(t, "http://127.0.0.1:1"),
+		nativeExtraction: true,
+		metadataMapping:  metadataMapping,
+		contentLimits:    ContentLimits{TikaMaxBytes: 1000},
+		logger:           logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := downloadDocumentSource(body, filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "notes.txt", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	require.Equal(t, sha256Hex(content), docs[0].ID)
+	require.Equal(t, int64(len(content)), docs[0].File.Filesize)
+	require.True(t, docs[0].File.Truncated)
+}
+
+func TestDocumentProcessor_ParseDocument_NativeFallback(t *testing.T) {
+	//setup
+	tikaRequests := 0
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tikaRequests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "page.html")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("<html><head><title>Recipes</title></head><body><p>Pancakes</p></body></html>"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:            newTestTikaPool(t, tikaServer.URL),
+		metadataMapping: metadataMapping,
+		logger:          logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "recipes/page.html", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 1, tikaRequests)
+	require.Equal(t, "Pancakes", docs[0].Content)
+	require.Equal(t, "Recipes", docs[0].Meta.Title)
+	require.Equal(t, extractionMethodNative, docs[0].File.ExtractionMethod)
+}
+
+func TestDocumentProcessor_ParseDocument_NativeFallbackTikaUnavailable(t *testing.T) {
+	//setup
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected tika request: %s", r.URL.Path)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "notes.txt")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("Buy milk"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	pool := newTestTikaPool(t, tikaServer.URL)
+	pool.servers[0].healthy = false
+	pool.servers[0].lastChecked = time.Now().Add(time.Hour) //do not recheck
+	proc := DocumentProcessor{
+		tika:            pool,
+		metadataMapping: metadataMapping,
+		logger:          logrus.WithField("test", t.Name()),
+	}
+	require.False(t, proc.requiresTika("notes/notes.txt", ""))
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "notes/notes.txt", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, "Buy milk", docs[0].Content)
+	require.Equal(t, extractionMethodNative, docs[0].File.ExtractionMethod)
+}
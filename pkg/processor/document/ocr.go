@@ -0,0 +1,229 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Tika PDF OCR strategies, see https://cwiki.apache.org/confluence/display/TIKA/TikaOCR
+const (
+	ocrStrategyAuto       = "auto"
+	ocrStrategyNoOcr      = "no_ocr"
+	ocrStrategyOcrOnly    = "ocr_only"
+	ocrStrategyOcrAndText = "ocr_and_text"
+)
+
+// methods used to extract the content of a document, stored in file.extraction_method
+const (
+	extractionMethodTika    = "tika"
+	extractionMethodTikaOcr = "tika_ocr"
+	extractionMethodNative  = "native"
+)
+
+// OcrConfig configures the OCR options sent to Tika.
+type OcrConfig struct {
+	// default OCR language(s), eg. "eng+deu". Used when a rule does not specify any languages.
+	LanguageOverride string
+
+	// path to a JSON or YAML file containing OCR rules, see ocrRule.
+	RulesPath string
+
+	// resend PDFs with OCR forced when the extracted text quality is lower than FallbackMinQuality (0-1)
+	Fallback           bool
+	FallbackMinQuality float64
+}
+
+func (oc OcrConfig) Validate() error {
+	if oc.FallbackMinQuality < 0 || oc.FallbackMinQuality > 1 {
+		return fmt.Errorf("ocr: fallback min quality must be between 0 and 1")
+	}
+	return nil
+}
+
+type ocrRules struct {
+	Rules []ocrRule `json:"rules" yaml:"rules"`
+}
+
+// ocrRule configures the OCR settings for documents matching any of its content types (glob patterns, eg.
+// "image/*") and any of its path prefixes (folders, eg. "scans" matches "scans/receipt.pdf" but not
+// "scans2019/receipt.pdf"). Empty lists match every document. The first matching rule is used.
+type ocrRule struct {
+	ContentTypes []string `json:"content_types" yaml:"content_types"`
+	PathPrefixes []string `json:"path_prefixes" yaml:"path_prefixes"`
+
+	Strategy  string   `json:"strategy" yaml:"strategy"`
+	Languages []string `json:"languages" yaml:"languages"`
+	DPI       int      `json:"dpi" yaml:"dpi"`
+	Timeout   string   `json:"timeout" yaml:"timeout"` //eg. "10m", applies to Tesseract and the Tika request.
+}
+
+// ocrSettings are the resolved OCR options for a single document
+type ocrSettings struct {
+	Strategy  string
+	Languages []string
+	DPI       int
+	Timeout   time.Duration
+}
+
+func loadOcrRules(rulesPath string) ([]ocrRule, error) {
+	if rulesPath == "" {
+		return nil, nil
+	}
+
+	rulesData, err := ioutil.ReadFile(rulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open ocr rules file: %v", err)
+	}
+
+	var rules ocrRules
+	switch strings.ToLower(filepath.Ext(rulesPath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(rulesData, &rules)
+	default:
+		err = json.Unmarshal(rulesData, &rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ocr rules file: %v", err)
+	}
+
+	for i, rule := range rules.Rules {
+		switch rule.Strategy {
+		case "", ocrStrategyAuto, ocrStrategyNoOcr, ocrStrategyOcrOnly, ocrStrategyOcrAndText:
+		default:
+			return nil, fmt.Errorf("ocr rule %d: unknown strategy %q", i, rule.Strategy)
+		}
+		if rule.DPI < 0 {
+			return nil, fmt.Errorf("ocr rule %d: dpi cannot be negative", i)
+		}
+		if rule.Timeout != "" {
+			if _, err := time.ParseDuration(rule.Timeout); err != nil {
+				return nil, fmt.Errorf("ocr rule %d: invalid timeout %q", i, rule.Timeout)
+			}
+		}
+		for _, pattern := range rule.ContentTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("ocr rule %d: invalid content type pattern %q", i, pattern)
+			}
+		}
+	}
+	return rules.Rules, nil
+}
+
+func (rule ocrRule) matches(bucketPath string, contentType string) bool {
+	contentTypeMatch := len(rule.ContentTypes) == 0
+	for _, pattern := range rule.ContentTypes {
+		if matched, _ := path.Match(pattern, contentType); matched {
+			contentTypeMatch = true
+			break
+		}
+	}
+
+	pathMatch := len(rule.PathPrefixes) == 0
+	for _, prefix := range rule.PathPrefixes {
+		folder := strings.Trim(prefix, "/")
+		if folder == "" || bucketPath == folder || strings.HasPrefix(bucketPath, folder+"/") {
+			pathMatch = true
+			break
+		}
+	}
+	return contentTypeMatch && pathMatch
+}
+
+// ocrSettingsFor returns the OCR settings of the first rule matching the document, falling back to the global OCR
+// language override.
+func (dp *DocumentProcessor) ocrSettingsFor(bucketPath string, contentType string) ocrSettings {
+	settings := ocrSettings{}
+	if dp.ocrLanguageOverride != "" {
+		settings.Languages = []string{dp.ocrLanguageOverride}
+	}
+
+	for _, rule := range dp.ocrRules {
+		if !rule.matches(bucketPath, contentType) {
+			continue
+		}
+		settings.Strategy = rule.Strategy
+		settings.DPI = rule.DPI
+		if len(rule.Languages) > 0 {
+			settings.Languages = rule.Languages
+		}
+		if rule.Timeout != "" {
+			settings.Timeout, _ = time.ParseDuration(rule.Timeout)
+		}
+		break
+	}
+	return settings
+}
+
+// needsOcrFallback determines if a document should be resent to Tika with OCR forced. Only PDFs are retried, as they
+// commonly contain scanned pages, or text with a broken font encoding.
+func (dp *DocumentProcessor) needsOcrFallback(contentType string, quality float64, settings ocrSettings) bool {
+	if !dp.ocrFallback || settings.Strategy == ocrStrategyNoOcr || settings.Strategy == ocrStrategyOcrOnly {
+		return false
+	}
+	return strings.HasPrefix(contentType, "application/pdf") && quality < dp.ocrFallbackMinQuality
+}
+
+// detectContentType guesses the content type of a file (using its name, or its first bytes) before it is sent to
+// Tika, so OCR rules can be applied.
+func detectContentType(fileName string, head []byte) string {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName)))
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// textQuality scores extracted text between 0 (empty, or garbage) and 1 (only readable words). Text extracted from
+// scanned documents or PDFs with broken font encodings mostly consists of symbols, control characters and short
+// fragments without letters.
+func textQuality(text string) float64 {
+	tokens := strings.Fields(text)
+	if len(tokens) == 0 {
+		return 0
+	}
+
+	totalChars, validChars := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		totalChars++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(".,;:!?'\"()[]-–%€$£&/@#+*=", r) {
+			validChars++
+		}
+	}
+
+	words := 0
+	for _, token := range tokens {
+		letters := 0
+		for _, r := range token {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				letters++
+			}
+		}
+		//most characters of a word are letters (or digits), allowing for punctuation
+		if letters > 0 && letters*2 >= utf8.RuneCountInString(token) {
+			words++
+		}
+	}
+
+	quality := float64(validChars) / float64(totalChars) * float64(words) / float64(len(tokens))
+	return math.Round(quality*100) / 100
+}
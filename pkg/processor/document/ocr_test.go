@@ -0,0 +1,202 @@
+package document
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOcrRules(t *testing.T) {
+	//setup
+	rulesPath := writeMetadataMapping(t, "ocr*.yaml", `
+rules:
+  - path_prefixes: ["scans/"]
+    strategy: ocr_only
+    languages: ["eng", "deu"]
+    dpi: 300
+    timeout: 10m
+  - content_types: ["image/*"]
+    strategy: auto
+`)
+	defer os.Remove(rulesPath)
+
+	//test
+	rules, err := loadOcrRules(rulesPath)
+
+	//assert
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, []string{"scans/"}, rules[0].PathPrefixes)
+	require.Equal(t, ocrStrategyOcrOnly, rules[0].Strategy)
+	require.Equal(t, 300, rules[0].DPI)
+	require.Equal(t, []string{"image/*"}, rules[1].ContentTypes)
+}
+
+func TestLoadOcrRules_Invalid(t *testing.T) {
+	var tests = []string{
+		`{"rules": [{"strategy": "always"}]}`,
+		`{"rules": [{"timeout": "ten minutes"}]}`,
+		`{"rules": [{"content_types": ["image/["]}]}`,
+		`{"rules": [{"dpi": -1}]}`,
+	}
+
+	for _, tt := range tests {
+		rulesPath := writeMetadataMapping(t, "ocr*.json", tt)
+		_, err := loadOcrRules(rulesPath)
+		os.Remove(rulesPath)
+		require.Error(t, err, "rules: %s", tt)
+	}
+}
+
+func TestDocumentProcessor_OcrSettingsFor(t *testing.T) {
+	//setup
+	proc := DocumentProcessor{
+		ocrLanguageOverride: "fra",
+		ocrRules: []ocrRule{
+			{PathPrefixes: []string{"/scans/"}, Strategy: ocrStrategyOcrOnly, Languages: []string{"eng", "deu"}, DPI: 300, Timeout: "10m"},
+			{ContentTypes: []string{"image/*"}, Strategy: ocrStrategyAuto},
+			{ContentTypes: []string{"application/pdf"}, PathPrefixes: []string{"ebooks/"}, Strategy: ocrStrategyNoOcr},
+			{PathPrefixes: []string{"taxes"}, Strategy: ocrStrategyNoOcr},
+		},
+	}
+
+	//test & assert
+	require.Equal(t, ocrSettings{Strategy: ocrStrategyOcrOnly, Languages: []string{"eng", "deu"}, DPI: 300, Timeout: 10 * time.Minute}, proc.ocrSettingsFor("scans/2019/receipt.pdf", "application/pdf"))
+	require.Equal(t, ocrSettings{Strategy: ocrStrategyAuto, Languages: []string{"fra"}}, proc.ocrSettingsFor("photos/whiteboard.png", "image/png"))
+	require.Equal(t, ocrSettings{Strategy: ocrStrategyNoOcr, Languages: []string{"fra"}}, proc.ocrSettingsFor("ebooks/novel.pdf", "application/pdf"))
+	require.Equal(t, ocrSettings{Strategy: ocrStrategyNoOcr, Languages: []string{"fra"}}, proc.ocrSettingsFor("taxes/return.pdf", "application/pdf"))
+	require.Equal(t, ocrSettings{Languages: []string{"fra"}}, proc.ocrSettingsFor("taxes2019/return.pdf", "application/pdf"), "sibling folder")
+	require.Equal(t, ocrSettings{Languages: []string{"fra"}}, proc.ocrSettingsFor("scans2019/receipt.pdf", "application/pdf"), "sibling folder")
+	require.Equal(t, ocrSettings{Languages: []string{"fra"}}, proc.ocrSettingsFor("invoices/return.pdf", "application/pdf"), "no matching rule")
+}
+
+func TestTextQuality(t *testing.T) {
+	var tests = []struct {
+		text     string
+		expected float64
+	}{
+		{"", 0},
+		{"   \n\n ", 0},
+		{"The quick brown fox jumps over the lazy dog.", 1},
+		{"Invoice #1234, total: €56.78 (paid)", 1},
+		{"\x01\x02 \x03 ~^ |\\ {} <>", 0},
+		{"�� �� ��� some text", 0.21},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, textQuality(tt.text), "text: %q", tt.text)
+	}
+}
+
+func TestTikaRoundTripper_OcrHeaders(t *testing.T) {
+	//setup
+	var headers http.Header
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	defer tikaServer.Close()
+
+	client := &http.Client{Transport: TikaRoundTripper{
+		r:   http.DefaultTransport,
+		ocr: ocrSettings{Strategy: ocrStrategyOcrOnly, Languages: []string{"eng", "deu"}, DPI: 300, Timeout: 10 * time.Minute},
+	}}
+
+	//test
+	resp, err := client.Post(tikaServer.URL+"/rmeta/xml", "application/pdf", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	//assert
+	require.Equal(t, []string{"eng+deu"}, headers["X-Tika-Ocrlanguage"])
+	require.Equal(t, []string{"ocr_only"}, headers["X-Tika-Pdfocrstrategy"])
+	require.Equal(t, []string{"300"}, headers["X-Tika-Pdfocrdpi"])
+	require.Equal(t, []string{"600"}, headers["X-Tika-Ocrtimeoutseconds"])
+}
+
+func TestDocumentProcessor_ParseDocument_OcrFallback(t *testing.T) {
+	//setup
+	requests := 0
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Tika-PDFOcrStrategy") == ocrStrategyOcrOnly {
+			fmt.Fprint(w, `[{"Content-Type": "application/pdf", "X-TIKA:content": "<html><body><div class=\"page\"><p>Scanned receipt total 12.50</p></div></body></html>"}]`)
+		} else {
+			fmt.Fprint(w, `[{"Content-Type": "application/pdf", "X-TIKA:content": "<html><body><div class=\"page\"><p>\u0001\u0002 ~~ |\\ {}</p></div></body></html>"}]`)
+		}
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "receipt.pdf")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("%PDF-1.4 scanned"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:                  newTestTikaPool(t, tikaServer.URL),
+		metadataMapping:       metadataMapping,
+		ocrFallback:           true,
+		ocrFallbackMinQuality: 0.5,
+		logger:                logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "receipts/receipt.pdf", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 2, requests)
+	require.Equal(t, "Scanned receipt total 12.50", docs[0].Content)
+	require.Equal(t, extractionMethodTikaOcr, docs[0].File.ExtractionMethod)
+	require.Equal(t, float64(1), docs[0].File.TextQuality)
+}
+
+func TestDocumentProcessor_ParseDocument_OcrFallbackDisabled(t *testing.T) {
+	//setup
+	requests := 0
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"Content-Type": "application/pdf", "X-TIKA:content": ""}]`)
+	}))
+	defer tikaServer.Close()
+
+	dir, err := ioutil.TempDir("", "doc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "empty.pdf")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("%PDF-1.4 empty"), 0644))
+
+	metadataMapping, err := loadMetadataMapping("../../../static/document-processor/metadata_mapping.json")
+	require.NoError(t, err)
+	proc := DocumentProcessor{
+		tika:            newTestTikaPool(t, tikaServer.URL),
+		metadataMapping: metadataMapping,
+		logger:          logrus.WithField("test", t.Name()),
+	}
+
+	//test
+	source, err := newLocalDocumentSource(filePath)
+	require.NoError(t, err)
+	docs, err := proc.parseDocument("documents", "empty.pdf", source)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 1, requests)
+	require.Equal(t, "", docs[0].Content)
+	require.Equal(t, extractionMethodTika, docs[0].File.ExtractionMethod)
+	require.Equal(t, float64(0), docs[0].File.TextQuality)
+}
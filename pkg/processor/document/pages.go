@@ -0,0 +1,132 @@
+package document
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// Tika marks page boundaries in its XHTML output using `<div class="page">` (PDF) and `<div class="slide-content">`
+// (PowerPoint) elements. Word processing formats are not paginated by Tika, so those documents will not have any pages.
+var xhtmlPageClasses = []string{"page", "slide-content"}
+
+// elements whose content should not be indexed
+var xhtmlIgnoredElements = map[string]bool{"head": true, "script": true, "style": true}
+
+// elements which separate blocks of text
+var xhtmlBlockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "tr": true, "td": true, "th": true, "table": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true, "section": true,
+}
+
+// parseXhtmlContent converts the XHTML content returned by Tika into plain text, and splits out the text of every page.
+// If the content is not an XHTML document, it is returned as-is (trimmed), without pages. Malformed documents are
+// converted up to the first error.
+func parseXhtmlContent(xhtml string) (string, []model.DocPage) {
+	if !strings.Contains(strings.ToLower(xhtml), "<html") {
+		return normalizeText(xhtml), nil
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(xhtml))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var content strings.Builder
+	var pageContent *strings.Builder
+	var pages []model.DocPage
+
+	depth := 0
+	ignoreDepth := 0 //depth of the ignored element we are currently in (0 if none)
+	pageDepth := 0   //depth of the page element we are currently in (0 if none)
+
+	writeText := func(text string) {
+		content.WriteString(text)
+		if pageContent != nil {
+			pageContent.WriteString(text)
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			//io.EOF, or a syntax error
+			break
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			name := strings.ToLower(element.Name.Local)
+			if ignoreDepth == 0 && xhtmlIgnoredElements[name] {
+				ignoreDepth = depth
+			}
+			if ignoreDepth != 0 {
+				continue
+			}
+			if pageDepth == 0 && name == "div" && isPageElement(element) {
+				pageDepth = depth
+				pageContent = &strings.Builder{}
+			}
+			if xhtmlBlockElements[name] || name == "br" {
+				writeText("\n")
+			}
+
+		case xml.EndElement:
+			name := strings.ToLower(element.Name.Local)
+			if ignoreDepth == 0 && xhtmlBlockElements[name] {
+				writeText("\n")
+			}
+			if depth == ignoreDepth {
+				ignoreDepth = 0
+			}
+			if depth == pageDepth {
+				pages = append(pages, model.DocPage{
+					Number:  len(pages) + 1,
+					Content: normalizeText(pageContent.String()),
+				})
+				pageDepth = 0
+				pageContent = nil
+			}
+			depth--
+
+		case xml.CharData:
+			if ignoreDepth == 0 {
+				writeText(string(element))
+			}
+		}
+	}
+
+	return normalizeText(content.String()), pages
+}
+
+func isPageElement(element xml.StartElement) bool {
+	for _, attr := range element.Attr {
+		if attr.Name.Local != "class" {
+			continue
+		}
+		for _, class := range strings.Fields(attr.Value) {
+			for _, pageClass := range xhtmlPageClasses {
+				if class == pageClass {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// normalizeText trims every line, and collapses consecutive blank lines.
+func normalizeText(text string) string {
+	lines := strings.Split(text, "\n")
+	normalized := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" && (len(normalized) == 0 || normalized[len(normalized)-1] == "") {
+			continue
+		}
+		normalized = append(normalized, line)
+	}
+	return strings.TrimSpace(strings.Join(normalized, "\n"))
+}
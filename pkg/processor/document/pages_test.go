@@ -0,0 +1,71 @@
+package document
+
+import (
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseXhtmlContent(t *testing.T) {
+	var tests = []struct {
+		name            string
+		xhtml           string
+		expectedContent string
+		expectedPages   []model.DocPage
+	}{
+		{
+			name:            "plain text",
+			xhtml:           "\n  plain text content  \n\n\n second line, 1 < 2 ",
+			expectedContent: "plain text content\n\nsecond line, 1 < 2",
+		},
+		{
+			name: "pdf",
+			xhtml: `<?xml version="1.0" encoding="UTF-8"?><html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<meta name="xmpTPg:NPages" content="3" />
+<title>2018 Form 4868</title>
+</head>
+<body><div class="page"><p />
+<p>Form 4868
+Application for Automatic Extension</p>
+<div class="annotation"><a href="https://www.irs.gov">irs.gov</a></div>
+</div>
+<div class="page"><p />
+<p>Page two &amp; more</p>
+</div>
+<div class="page"></div>
+</body></html>`,
+			expectedContent: "Form 4868\nApplication for Automatic Extension\n\nirs.gov\n\nPage two & more",
+			expectedPages: []model.DocPage{
+				{Number: 1, Content: "Form 4868\nApplication for Automatic Extension\n\nirs.gov"},
+				{Number: 2, Content: "Page two & more"},
+				{Number: 3, Content: ""},
+			},
+		},
+		{
+			name:            "presentation",
+			xhtml:           `<html><body><div class="slide-content"><p>Title Slide</p></div><div class="slide-notes"><p>notes</p></div><div class="slide-content"><p>Agenda</p><ul><li>one</li><li>two</li></ul></div></body></html>`,
+			expectedContent: "Title Slide\n\nnotes\n\nAgenda\n\none\n\ntwo",
+			expectedPages: []model.DocPage{
+				{Number: 1, Content: "Title Slide"},
+				{Number: 2, Content: "Agenda\n\none\n\ntwo"},
+			},
+		},
+		{
+			name:            "word document",
+			xhtml:           `<html><head><title>Letter</title><style>p {}</style></head><body><h1>Dear Sir</h1><p>Body<br/>text</p></body></html>`,
+			expectedContent: "Dear Sir\n\nBody\ntext",
+		},
+		{
+			name:            "malformed",
+			xhtml:           `<html><body><p>unclosed & broken</body>`,
+			expectedContent: "unclosed & broken",
+		},
+	}
+
+	for _, tt := range tests {
+		content, pages := parseXhtmlContent(tt.xhtml)
+		require.Equal(t, tt.expectedContent, content, tt.name)
+		require.Equal(t, tt.expectedPages, pages, tt.name)
+	}
+}
@@ -0,0 +1,86 @@
+package document
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// rawMetadata returns the complete Tika metadata map, so that it can be stored under meta.raw (see the meta.raw.*
+// dynamic template in settings.json). Keys are normalized so they are safe to use as Elasticsearch field names, and
+// filtered using the include/exclude patterns.
+func (dp *DocumentProcessor) rawMetadata(parsedMeta map[string]interface{}) map[string]interface{} {
+	//iterate in a stable order, so that values of keys which normalize to the same field name are always merged in the same order
+	keys := make([]string, 0, len(parsedMeta))
+	for key := range parsedMeta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	raw := map[string]interface{}{}
+	for _, key := range keys {
+		val := parsedMeta[key]
+		if !dp.validRawMetadataKey(key) {
+			continue
+		}
+
+		normalizedKey := normalizeMetadataKey(key)
+		if normalizedKey == "" {
+			continue
+		}
+
+		//raw metadata is mapped as text, so every value is stored as a (list of) strings
+		values := metadataValues(val)
+		if len(values) == 0 {
+			continue
+		}
+
+		//multiple tika keys may normalize to the same field name, merge their values.
+		if existing, found := raw[normalizedKey]; found {
+			values = append(metadataValues(existing), values...)
+		}
+
+		if len(values) == 1 {
+			raw[normalizedKey] = values[0]
+		} else {
+			raw[normalizedKey] = values
+		}
+	}
+	return raw
+}
+
+// include patterns are treated as an allow list (when specified), exclude patterns are always removed.
+// patterns are matched against the original Tika key, and support the same syntax as path.Match (eg. "access_permission:*")
+func (dp *DocumentProcessor) validRawMetadataKey(key string) bool {
+	if len(dp.metaRawInclude) > 0 && !matchesAnyPattern(dp.metaRawInclude, key) {
+		return false
+	}
+	return !matchesAnyPattern(dp.metaRawExclude, key)
+}
+
+func matchesAnyPattern(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, key); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Elasticsearch treats "." in field names as object paths, and does not allow empty field names. Replace any
+// characters that are not letters, digits or one of ":-_" with an underscore.
+// eg. "pdf:docinfo:custom:Form fields" => "pdf:docinfo:custom:Form_fields"
+func normalizeMetadataKey(key string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ':' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(key))
+
+	for strings.Contains(normalized, "__") {
+		normalized = strings.Replace(normalized, "__", "_", -1)
+	}
+	return strings.Trim(normalized, "_")
+}
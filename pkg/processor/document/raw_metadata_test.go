@@ -0,0 +1,54 @@
+package document
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalizeMetadataKey(t *testing.T) {
+	var tests = []struct {
+		key      string
+		expected string
+	}{
+		{"dc:title", "dc:title"},
+		{"Content-Type", "Content-Type"},
+		{"pdf:docinfo:custom:Form fields", "pdf:docinfo:custom:Form_fields"},
+		{"Exif SubIFD:F-Number", "Exif_SubIFD:F-Number"},
+		{"tiff:Image.Width", "tiff:Image_Width"},
+		{"  .hidden..key.  ", "hidden_key"},
+		{"...", ""},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, normalizeMetadataKey(tt.key), tt.key)
+	}
+}
+
+func TestDocumentProcessor_RawMetadata(t *testing.T) {
+
+	//setup
+	proc := DocumentProcessor{
+		metaRawInclude: []string{"pdf:*", "custom.*", "Count"},
+		metaRawExclude: []string{"pdf:docinfo:*"},
+	}
+
+	//test
+	raw := proc.rawMetadata(map[string]interface{}{
+		"Content-Type":         "application/pdf",
+		"pdf:PDFVersion":       "1.7",
+		"pdf:charsPerPage":     []interface{}{"5339", "5284"},
+		"pdf:docinfo:title":    "2018 Form 4868",
+		"pdf:encrypted":        "",
+		"custom.Project Name":  "lodestone",
+		"custom.Project__Name": "processor",
+		"Count":                float64(12),
+	})
+
+	//assert
+	require.Equal(t, map[string]interface{}{
+		"pdf:PDFVersion":      "1.7",
+		"pdf:charsPerPage":    []string{"5339", "5284"},
+		"custom_Project_Name": []string{"lodestone", "processor"},
+		"Count":               "12",
+	}, raw)
+}
@@ -0,0 +1,54 @@
+package document
+
+import "strings"
+
+// common words of the languages with a language specific analyzer (see model.ContentLanguages), which are ignored when
+// ranking summary sentences and separate keyword phrases. Words shorter than 3 characters are always ignored.
+var stopWordsByLanguage = map[string]map[string]bool{
+	"en": newStopWords(`the and for are but not you all any can had her was one our out has him his how its may new now
+		see two who did get let she too use that with have this will your from they been were said each which their there
+		would what about when them these some into than then also other could should only such more most very shall upon
+		being does where while after before because those here just over under per via within between through during both
+		same own off yes why many much well`),
+	"de": newStopWords(`der die das den dem des ein eine einer eines einem einen und oder aber nicht ist sind war waren
+		wird werden wurde wurden hat haben hatte sein ich sie wir ihr mit von für auf aus bei nach vor über unter durch
+		gegen ohne zum zur als noch nur wie wenn dass sich diese dieser dieses diesem diesen kann können muss soll
+		sollen auch schon sehr mehr bis doch dann denn hier dort alle man ihre ihren seine seinen uns euch wieder`),
+	"fr": newStopWords(`les des une aux avec dans pour par sur sous mais est sont était être avoir ont fait elle ils
+		elles nous vous leur leurs son ses sans que qui quoi dont cette ces cet comme plus moins tout tous toute toutes
+		aussi bien très peut entre après avant chez encore depuis donc ainsi alors notre nos votre vos même autre`),
+	"es": newStopWords(`los las una unos unas del con para por sin sobre entre pero que como más menos muy este
+		esta estos estas ese esa esos esas aquel sus ser estar son está están fue han hay había sido también todo todos
+		toda todas otro otra otros otras cuando donde desde hasta porque nos les ella ellos ellas usted nuestro vuestro`),
+	"it": newStopWords(`gli una uno del della dello dei degli delle nel nella nei nelle con per tra fra che chi non
+		sono era erano essere avere hanno come più meno molto questo questa questi queste quello quella quelli suo sua
+		suoi sue loro anche tutto tutti tutte altro altra altri quando dove perché dopo prima senza sopra sotto ancora`),
+	"nl": newStopWords(`het een van voor met aan als bij door dat die dit deze naar niet ook maar dan wel nog hun
+		zijn was waren wordt worden werd heeft hebben had kan kunnen moet zal zou over onder tegen tot uit wat wie waar
+		hoe omdat want geen meer zeer veel alle ons onze jullie haar hem zich zelf hier daar toen`),
+	"pt": newStopWords(`uma umas uns dos das com para por sem sobre entre mas que como mais menos muito este esta
+		estes estas esse essa esses essas aquele seu sua seus suas ser estar são está estão foi tem têm também todo
+		todos toda todas outro outra outros outras quando onde desde até porque nos lhe eles elas você nosso`),
+	"ru": newStopWords(`что это как так его она они оно мне мой моя наш ваш вас нас них ему был была были было
+		быть есть для или при над под без про через после перед между когда где куда тоже также уже еще ещё только
+		очень все всё весь вся этот эта эти тот тех чем чтобы если даже может можно нет`),
+}
+
+func newStopWords(words string) map[string]bool {
+	stopWords := map[string]bool{}
+	for _, word := range strings.Fields(words) {
+		stopWords[word] = true
+	}
+	return stopWords
+}
+
+// languageStopWords returns the stop words of the language, and false for languages without stop words. Documents of
+// an unknown language (language detection is disabled, and the metadata does not specify a language) use the english
+// stop words.
+func languageStopWords(language string) (map[string]bool, bool) {
+	if language == "" {
+		language = "en"
+	}
+	stopWords, ok := stopWordsByLanguage[language]
+	return stopWords, ok
+}
@@ -0,0 +1,226 @@
+package document
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// SummaryConfig configures the extractive summary stored in the `summary` field of a document, so search results can
+// show a preview of the content. A zero Sentences value disables summaries.
+type SummaryConfig struct {
+	// maximum number of sentences in a summary
+	Sentences int
+
+	// maximum length of a summary (in characters), sentences that do not fit are skipped. 0 disables the limit.
+	MaxChars int
+
+	// documents with less content are not summarized, their content is short enough to be previewed as-is
+	MinContentChars int
+}
+
+func (sc SummaryConfig) Validate() error {
+	if sc.Sentences < 0 {
+		return fmt.Errorf("summary: sentences cannot be negative")
+	}
+	if sc.MaxChars < 0 {
+		return fmt.Errorf("summary: max characters cannot be negative")
+	}
+	if sc.MinContentChars < 0 {
+		return fmt.Errorf("summary: min content characters cannot be negative")
+	}
+	return nil
+}
+
+const (
+	// number of content characters used to summarize a document
+	summarySampleChars = 50000
+
+	// sentences with fewer or more words (eg. headings, table rows) are not used in summaries
+	summaryMinSentenceWords = 5
+	summaryMaxSentenceWords = 60
+)
+
+var (
+	// end of a sentence: punctuation, optionally followed by closing quotes or brackets, and whitespace
+	sentenceEndPattern = regexp.MustCompile(`[.!?]+["'”’)\]]*\s+`)
+
+	// paragraphs are separated by blank lines, single line breaks are usually wrapped lines
+	paragraphSeparatorPattern = regexp.MustCompile(`\n[ \t]*\n`)
+
+	// abbreviations that are not the end of a sentence
+	sentenceAbbreviations = map[string]bool{
+		"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "no": true, "nr": true, "vs": true,
+		"inc": true, "ltd": true, "co": true, "corp": true, "e.g": true, "i.e": true, "etc": true, "approx": true,
+		"fig": true, "ca": true, "jan": true, "feb": true, "mar": true, "apr": true, "jun": true, "jul": true,
+		"aug": true, "sep": true, "sept": true, "oct": true, "nov": true, "dec": true,
+	}
+)
+
+// summarize sets the extractive summary of the document: the highest ranking sentences of the content, in the order
+// they appear. Sentences are ranked by the frequency of their words in the document, so the result is deterministic.
+func (dp *DocumentProcessor) summarize(doc *model.Document) {
+	config := dp.summaryConfig
+	if config.Sentences == 0 || utf8.RuneCountInString(doc.Content) < config.MinContentChars {
+		return
+	}
+	sample, _ := truncateText(doc.Content, summarySampleChars)
+	//without stop words, the words used in most sentences are still ignored
+	stopWords, _ := languageStopWords(doc.Language)
+	doc.Summary = summarizeText(sample, stopWords, config.Sentences, config.MaxChars)
+}
+
+func summarizeText(text string, stopWords map[string]bool, maxSentences int, maxChars int) string {
+	type candidate struct {
+		position int
+		sentence string
+		words    []string
+		score    float64
+	}
+	var candidates []candidate
+	for position, sentence := range splitSentences(text) {
+		words := sentenceWords(sentence, stopWords)
+		if len(words) < summaryMinSentenceWords || len(words) > summaryMaxSentenceWords || !isProse(sentence) {
+			continue
+		}
+		candidates = append(candidates, candidate{position: position, sentence: sentence, words: words})
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	//count the number of sentences using every word
+	frequencies := map[string]int{}
+	for _, c := range candidates {
+		seen := map[string]bool{}
+		for _, word := range c.words {
+			if !seen[word] {
+				seen[word] = true
+				frequencies[word]++
+			}
+		}
+	}
+	maxFrequency := 0
+	for word, frequency := range frequencies {
+		if len(candidates) >= 4 && frequency*2 > len(candidates) {
+			//used in most sentences, eg. stop words of languages without a stop word list
+			delete(frequencies, word)
+		} else if frequency > maxFrequency {
+			maxFrequency = frequency
+		}
+	}
+	if maxFrequency == 0 {
+		return ""
+	}
+
+	for i := range candidates {
+		c := &candidates[i]
+		score := 0.0
+		for _, word := range c.words {
+			score += float64(frequencies[word]) / float64(maxFrequency)
+		}
+		//normalized by the square root of the sentence length, so long sentences do not always win
+		c.score = score / math.Sqrt(float64(len(c.words)))
+		//the first sentences usually introduce the document
+		if i < len(candidates)/5+1 {
+			c.score *= 1.25
+		}
+	}
+
+	ranked := make([]candidate, len(candidates))
+	copy(ranked, candidates)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	var selected []candidate
+	length := 0
+	for _, c := range ranked {
+		if len(selected) == maxSentences {
+			break
+		}
+		sentenceLength := utf8.RuneCountInString(c.sentence)
+		if len(selected) > 0 {
+			sentenceLength++ //separator
+		}
+		if maxChars > 0 && length+sentenceLength > maxChars {
+			continue
+		}
+		selected = append(selected, c)
+		length += sentenceLength
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].position < selected[j].position
+	})
+
+	sentences := []string{}
+	for _, c := range selected {
+		sentences = append(sentences, c.sentence)
+	}
+	return strings.Join(sentences, " ")
+}
+
+// splitSentences splits text into sentences, with normalized whitespace
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, paragraph := range paragraphSeparatorPattern.Split(text, -1) {
+		paragraph = strings.TrimSpace(collapseWhitespace(paragraph))
+		start := 0
+		for _, end := range sentenceEndPattern.FindAllStringIndex(paragraph, -1) {
+			next, _ := utf8.DecodeRuneInString(paragraph[end[1]:])
+			if end[1] < len(paragraph) && !unicode.IsUpper(next) && !unicode.IsDigit(next) {
+				continue
+			}
+			//abbreviations and initials (eg. "J. Smith")
+			if word := lastWord(paragraph[start:end[0]]); sentenceAbbreviations[word] || utf8.RuneCountInString(word) == 1 {
+				continue
+			}
+			sentences = append(sentences, strings.TrimSpace(paragraph[start:end[1]]))
+			start = end[1]
+		}
+		if rest := strings.TrimSpace(paragraph[start:]); rest != "" {
+			sentences = append(sentences, rest)
+		}
+	}
+	return sentences
+}
+
+// sentenceWords returns the lowercase words of a sentence, without stop words and words shorter than 3 characters
+func sentenceWords(sentence string, stopWords map[string]bool) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(sentence), func(char rune) bool {
+		return !unicode.IsLetter(char) && !unicode.IsDigit(char)
+	}) {
+		if utf8.RuneCountInString(word) >= 3 && !stopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// isProse returns false for sentences that are mostly numbers or symbols, eg. tables or OCR noise
+func isProse(sentence string) bool {
+	letters, other := 0, 0
+	for _, char := range sentence {
+		if unicode.IsLetter(char) {
+			letters++
+		} else if !unicode.IsSpace(char) {
+			other++
+		}
+	}
+	return letters > other*2
+}
+
+func lastWord(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimLeft(fields[len(fields)-1], `"'“‘(`))
+}
@@ -0,0 +1,95 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+const summaryTestContent = `Quarterly Report
+
+The solar farm produced more energy than forecast during the third quarter. Energy production at the solar farm
+increased by twelve percent compared to the previous quarter.
+
+1,234 | 5,678 | 9,012 | 3,456 | 7,890
+
+Dr. Smith joined the maintenance team in August. The weather was unusually warm for the season. Maintenance of the
+solar panels was completed ahead of schedule, which reduced downtime of the farm. Questions about this report can be
+sent to the finance department.`
+
+func TestSplitSentences(t *testing.T) {
+	require.Equal(t, []string{
+		"Quarterly Report",
+		"The solar farm produced more energy than forecast during the third quarter.",
+		"Energy production at the solar farm increased by twelve percent compared to the previous quarter.",
+		"1,234 | 5,678 | 9,012 | 3,456 | 7,890",
+		"Dr. Smith joined the maintenance team in August.",
+		"The weather was unusually warm for the season.",
+		"Maintenance of the solar panels was completed ahead of schedule, which reduced downtime of the farm.",
+		"Questions about this report can be sent to the finance department.",
+	}, splitSentences(summaryTestContent))
+
+	require.Equal(t,
+		[]string{"See e.g. the appendix, or ask J. Smith!", "Thanks."},
+		splitSentences("See e.g. the appendix, or ask J. Smith! Thanks."),
+	)
+}
+
+func TestSummarize(t *testing.T) {
+	//setup
+	dp := DocumentProcessor{summaryConfig: SummaryConfig{Sentences: 2, MaxChars: 200, MinContentChars: 100}}
+	doc := model.Document{Content: summaryTestContent}
+
+	//test
+	dp.summarize(&doc)
+
+	//assert
+	require.Equal(t, "The solar farm produced more energy than forecast during the third quarter. "+
+		"Energy production at the solar farm increased by twelve percent compared to the previous quarter.", doc.Summary)
+
+	//deterministic
+	again := model.Document{Content: summaryTestContent}
+	dp.summarize(&again)
+	require.Equal(t, doc.Summary, again.Summary)
+}
+
+func TestSummarize_MaxChars(t *testing.T) {
+	dp := DocumentProcessor{summaryConfig: SummaryConfig{Sentences: 3, MaxChars: 100}}
+	doc := model.Document{Content: summaryTestContent}
+	dp.summarize(&doc)
+
+	require.True(t, len(doc.Summary) <= 100, doc.Summary)
+	require.NotEmpty(t, doc.Summary)
+}
+
+func TestSummarize_Skipped(t *testing.T) {
+	var tests = []struct {
+		config  SummaryConfig
+		content string
+	}{
+		{SummaryConfig{Sentences: 0}, summaryTestContent},
+		{SummaryConfig{Sentences: 3, MinContentChars: 1000}, summaryTestContent},
+		{SummaryConfig{Sentences: 3}, "Invoice\n\n1,234.00 | 5,678.00 | 9,012.00 | 3,456.00 | 7,890.00"},
+		{SummaryConfig{Sentences: 3, MaxChars: 10}, summaryTestContent},
+	}
+
+	for _, test := range tests {
+		//setup
+		dp := DocumentProcessor{summaryConfig: test.config}
+		doc := model.Document{Content: test.content}
+
+		//test
+		dp.summarize(&doc)
+
+		//assert
+		require.Equal(t, "", doc.Summary, test.config)
+	}
+}
+
+func TestSummaryConfig_Validate(t *testing.T) {
+	require.NoError(t, SummaryConfig{Sentences: 3, MaxChars: 500, MinContentChars: 1000}.Validate())
+	require.Error(t, SummaryConfig{Sentences: -1}.Validate())
+	require.Error(t, SummaryConfig{MaxChars: -1}.Validate())
+	require.Error(t, SummaryConfig{MinContentChars: -1}.Validate())
+}
@@ -0,0 +1,197 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// tagRules configures how lodestone.tags are derived from a document. By default (without a rules file) every folder
+// in the bucket path becomes a tag.
+type tagRules struct {
+	// only folders at these depths (1 is the top level folder, stop folders are counted) become tags. 0 disables the limit.
+	MinDepth int `json:"min_depth" yaml:"min_depth"`
+	MaxDepth int `json:"max_depth" yaml:"max_depth"`
+
+	// folders that never become tags, eg. "inbox" (case-insensitive)
+	StopFolders []string `json:"stop_folders" yaml:"stop_folders"`
+
+	// folder name -> tag, eg. "2019 taxes" -> "taxes" (case-insensitive, the names are lowercased when loaded)
+	Aliases map[string]string `json:"aliases" yaml:"aliases"`
+
+	// add the document keywords (meta.keywords) as tags
+	Keywords bool `json:"keywords" yaml:"keywords"`
+
+	Rules []tagRule `json:"rules" yaml:"rules"`
+}
+
+// tagRule adds tags to documents matching its path regular expression and any of its content types (glob patterns, eg.
+// "image/*"). Empty conditions match every document. Tags may reference path captures ("$1", "${year}"), when no tags
+// are configured every (non empty) capture becomes a tag. Every matching rule is applied.
+type tagRule struct {
+	Path         string   `json:"path" yaml:"path"`
+	ContentTypes []string `json:"content_types" yaml:"content_types"`
+	Tags         []string `json:"tags" yaml:"tags"`
+
+	pathPattern *regexp.Regexp
+}
+
+func loadTagRules(rulesPath string) (*tagRules, error) {
+	if rulesPath == "" {
+		return &tagRules{}, nil
+	}
+
+	rulesData, err := ioutil.ReadFile(rulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open tag rules file: %v", err)
+	}
+
+	var rules tagRules
+	switch strings.ToLower(filepath.Ext(rulesPath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(rulesData, &rules)
+	default:
+		err = json.Unmarshal(rulesData, &rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse tag rules file: %v", err)
+	}
+
+	if rules.MinDepth < 0 || rules.MaxDepth < 0 {
+		return nil, fmt.Errorf("tag rules: depth limits cannot be negative")
+	}
+	if rules.MaxDepth > 0 && rules.MinDepth > rules.MaxDepth {
+		return nil, fmt.Errorf("tag rules: min depth cannot be greater than max depth")
+	}
+	//aliases are matched case-insensitively, names that only differ in case would be ambiguous
+	aliases := map[string]string{}
+	for name, tag := range rules.Aliases {
+		if _, ok := aliases[strings.ToLower(name)]; ok {
+			return nil, fmt.Errorf("tag rules: duplicate alias %q (aliases are case-insensitive)", name)
+		}
+		aliases[strings.ToLower(name)] = tag
+	}
+	rules.Aliases = aliases
+
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Path == "" && len(rule.ContentTypes) == 0 {
+			return nil, fmt.Errorf("tag rule %d: a path or content type condition is required", i)
+		}
+		if rule.Path != "" {
+			rule.pathPattern, err = regexp.Compile(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("tag rule %d: invalid path pattern %q: %v", i, rule.Path, err)
+			}
+		}
+		if len(rule.Tags) == 0 && (rule.pathPattern == nil || rule.pathPattern.NumSubexp() == 0) {
+			return nil, fmt.Errorf("tag rule %d: tags or path captures are required", i)
+		}
+		for _, pattern := range rule.ContentTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("tag rule %d: invalid content type pattern %q", i, pattern)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// tags derives the tags of a document: folder tags first, followed by rule tags and keyword tags. Duplicates are
+// removed.
+func (tr *tagRules) tags(bucketPath string, contentType string, keywords []string) []string {
+	if tr == nil {
+		tr = &tagRules{}
+	}
+
+	var tags []string
+	addTag := func(tag string) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	bucketPathDir, _ := filepath.Split(bucketPath)
+	for depth, folder := range deleteEmpty(strings.Split(bucketPathDir, "/")) {
+		if depth+1 < tr.MinDepth || (tr.MaxDepth > 0 && depth+1 > tr.MaxDepth) || tr.isStopFolder(folder) {
+			continue
+		}
+		addTag(tr.alias(folder))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, rule := range tr.Rules {
+		for _, tag := range rule.apply(bucketPath, mediaType) {
+			addTag(tag)
+		}
+	}
+
+	if tr.Keywords {
+		for _, keyword := range keywords {
+			addTag(keyword)
+		}
+	}
+	return tags
+}
+
+func (tr *tagRules) isStopFolder(folder string) bool {
+	for _, stopFolder := range tr.StopFolders {
+		if strings.EqualFold(folder, stopFolder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tr *tagRules) alias(folder string) string {
+	if tag, ok := tr.Aliases[strings.ToLower(folder)]; ok {
+		return tag
+	}
+	return folder
+}
+
+// apply returns the tags of the rule, or nil if the document does not match.
+func (rule tagRule) apply(bucketPath string, mediaType string) []string {
+	if len(rule.ContentTypes) > 0 {
+		contentTypeMatch := false
+		for _, pattern := range rule.ContentTypes {
+			if matched, _ := path.Match(pattern, mediaType); matched {
+				contentTypeMatch = true
+				break
+			}
+		}
+		if !contentTypeMatch {
+			return nil
+		}
+	}
+
+	if rule.pathPattern == nil {
+		return rule.Tags
+	}
+	captures := rule.pathPattern.FindStringSubmatchIndex(bucketPath)
+	if captures == nil {
+		return nil
+	}
+	if len(rule.Tags) == 0 {
+		tags := []string{}
+		for i := 2; i < len(captures); i += 2 {
+			if captures[i] >= 0 {
+				tags = append(tags, bucketPath[captures[i]:captures[i+1]])
+			}
+		}
+		return tags
+	}
+
+	tags := []string{}
+	for _, template := range rule.Tags {
+		tags = append(tags, string(rule.pathPattern.ExpandString(nil, template, bucketPath, captures)))
+	}
+	return tags
+}
@@ -0,0 +1,73 @@
+package document
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTagRules(t *testing.T) {
+	//setup
+	rulesPath := writeMetadataMapping(t, "tags*.yaml", `
+max_depth: 2
+stop_folders: ["Inbox", "misc"]
+aliases:
+  "2019 Taxes": taxes
+keywords: true
+rules:
+  - path: '^(?:.*/)?(?P<year>(?:19|20)\d{2})[-_]'
+    tags: ["year:${year}"]
+  - path: '^clients/([^/]+)/'
+  - content_types: ["image/*"]
+    tags: ["photo"]
+`)
+	defer os.Remove(rulesPath)
+
+	//test
+	rules, err := loadTagRules(rulesPath)
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, 2, rules.MaxDepth)
+	require.Len(t, rules.Rules, 3)
+
+	require.Equal(t,
+		[]string{"taxes", "year:2019", "Fillable", "tax"},
+		rules.tags("inbox/2019 taxes/receipts/deep/2019-04-15_form.pdf", "application/pdf", []string{"Fillable", " tax ", "taxes"}),
+	)
+	require.Equal(t,
+		[]string{"clients", "acme", "photo"},
+		rules.tags("clients/acme/misc/logo.png", "image/png; charset=binary", nil),
+	)
+}
+
+func TestLoadTagRules_Default(t *testing.T) {
+	rules, err := loadTagRules("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"taxes", "2019"}, rules.tags("taxes/2019/form.pdf", "application/pdf", []string{"Fillable"}))
+	require.Nil(t, rules.tags("form.pdf", "application/pdf", nil))
+
+	//processors created without rules (eg. in tests) use the defaults
+	var noRules *tagRules
+	require.Equal(t, []string{"taxes", "2019"}, noRules.tags("taxes/2019/form.pdf", "application/pdf", nil))
+}
+
+func TestLoadTagRules_Invalid(t *testing.T) {
+	var tests = []string{
+		`{"min_depth": -1}`,
+		`{"min_depth": 3, "max_depth": 2}`,
+		`{"rules": [{"tags": ["unconditional"]}]}`,
+		`{"rules": [{"path": "^taxes/("}]}`,
+		`{"rules": [{"path": "^taxes/"}]}`,
+		`{"rules": [{"content_types": ["image/["], "tags": ["image"]}]}`,
+		`{"aliases": {"Taxes": "taxes", "TAXES": "tax"}}`,
+	}
+
+	for _, tt := range tests {
+		rulesPath := writeMetadataMapping(t, "tags*.json", tt)
+		_, err := loadTagRules(rulesPath)
+		os.Remove(rulesPath)
+		require.Error(t, err, "rules: %s", tt)
+	}
+}
@@ -2,26 +2,39 @@ package document
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type TikaRoundTripper struct {
-	r                   http.RoundTripper
-	ocrLanguageOverride string
+	r   http.RoundTripper
+	ocr ocrSettings
 }
 
 // https://cwiki.apache.org/confluence/display/tika/TikaJAXRS#TikaJAXRS-MultipartSupport TIKA must have an Accept header to return JSON responses.
 func (mrt TikaRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
-	if r.URL.Path == "/meta" {
+	if r.URL.Path == "/meta" || strings.HasPrefix(r.URL.Path, "/rmeta") {
 		r.Header.Add("Accept", "application/json")
 	}
 	if r.URL.Path == "/tika" {
 		r.Header.Add("Accept", "text/plain")
-
-		if mrt.ocrLanguageOverride != "" {
-			// Note: we are not using Header#Add here because it would mess up the key
-			// Go HTTP expects all headers to be case insensitive and converts the case to avoid ambiguity.
-			// Tika on the other hand treats headers case sensitive and ignores headers with messed up case
-			r.Header["X-Tika-OCRLanguage"] = append(r.Header["X-Tika-OCRLanguage"], mrt.ocrLanguageOverride)
+	}
+	if r.URL.Path == "/tika" || strings.HasPrefix(r.URL.Path, "/rmeta") {
+		// Note: we are not using Header#Add here because it would mess up the key
+		// Go HTTP expects all headers to be case insensitive and converts the case to avoid ambiguity.
+		// Tika on the other hand treats headers case sensitive and ignores headers with messed up case
+		// See https://cwiki.apache.org/confluence/display/TIKA/TikaServer#TikaServer-SpecifyingConfigurationOptions
+		if len(mrt.ocr.Languages) > 0 {
+			r.Header["X-Tika-OCRLanguage"] = append(r.Header["X-Tika-OCRLanguage"], strings.Join(mrt.ocr.Languages, "+"))
+		}
+		if mrt.ocr.Strategy != "" {
+			r.Header["X-Tika-PDFOcrStrategy"] = append(r.Header["X-Tika-PDFOcrStrategy"], mrt.ocr.Strategy)
+		}
+		if mrt.ocr.DPI > 0 {
+			r.Header["X-Tika-PDFOcrDPI"] = append(r.Header["X-Tika-PDFOcrDPI"], strconv.Itoa(mrt.ocr.DPI))
+		}
+		if mrt.ocr.Timeout > 0 {
+			r.Header["X-Tika-OCRTimeoutSeconds"] = append(r.Header["X-Tika-OCRTimeoutSeconds"], strconv.Itoa(int(mrt.ocr.Timeout.Seconds())))
 		}
 	}
 
@@ -0,0 +1,271 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/go-tika/tika"
+	"github.com/sirupsen/logrus"
+)
+
+// Tika server selection strategies
+const (
+	tikaBalancingRoundRobin = "round-robin"
+	tikaBalancingLeastBusy  = "least-busy"
+)
+
+// maximum time a Tika server has to respond to a health check
+const tikaHealthCheckTimeout = 10 * time.Second
+
+var errNoHealthyTikaServers = errors.New("no healthy tika servers available")
+
+// TikaConfig configures the Tika servers used to extract document content & metadata
+type TikaConfig struct {
+	Endpoints           []string
+	Balancing           string        //"round-robin" (default) or "least-busy"
+	Timeout             time.Duration //maximum time to process a single document, defaults to 5 minutes
+	MaxRetries          int           //number of times a request is retried after a transient error
+	RetryBackoff        time.Duration //delay before the first retry, doubled for every subsequent retry. Defaults to 1 second
+	HealthCheckInterval time.Duration //how often unhealthy servers are checked, defaults to 10 seconds
+}
+
+func (tc TikaConfig) Validate() error {
+	if len(tc.Endpoints) == 0 {
+		return fmt.Errorf("tika: at least one endpoint is required")
+	}
+	for _, endpoint := range tc.Endpoints {
+		if _, err := url.ParseRequestURI(endpoint); err != nil {
+			return fmt.Errorf("tika: invalid endpoint %q", endpoint)
+		}
+	}
+	if tc.Balancing != "" && tc.Balancing != tikaBalancingRoundRobin && tc.Balancing != tikaBalancingLeastBusy {
+		return fmt.Errorf("tika: unknown balancing strategy %q", tc.Balancing)
+	}
+	if tc.MaxRetries < 0 {
+		return fmt.Errorf("tika: max retries cannot be negative")
+	}
+	return nil
+}
+
+type tikaServer struct {
+	endpoint    string
+	inFlight    int
+	healthy     bool
+	lastChecked time.Time
+}
+
+// tikaPool distributes requests across the configured Tika servers. Servers are marked unhealthy when a request fails
+// with a transient error, and are health checked again after the health check interval. While no server is healthy
+// the pool acts as an open circuit breaker: requests block until a server recovers, which pauses message consumption.
+type tikaPool struct {
+	config  TikaConfig
+	servers []*tikaServer
+	next    int
+	mutex   sync.Mutex
+	logger  *logrus.Entry
+}
+
+func newTikaPool(config TikaConfig, logger *logrus.Entry) (*tikaPool, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Balancing == "" {
+		config.Balancing = tikaBalancingRoundRobin
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute * 5 //5 minutes max to process documents.
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = 10 * time.Second
+	}
+
+	pool := &tikaPool{config: config, logger: logger}
+	for _, endpoint := range config.Endpoints {
+		//servers are assumed to be healthy until a request or health check fails
+		pool.servers = append(pool.servers, &tikaServer{endpoint: endpoint, healthy: true})
+	}
+	return pool, nil
+}
+
+// httpClient returns a client that sends the OCR settings to Tika. OCR rules may override the request timeout.
+func (p *tikaPool) httpClient(ocr ocrSettings) *http.Client {
+	timeout := p.config.Timeout
+	if ocr.Timeout > 0 {
+		timeout = ocr.Timeout
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: TikaRoundTripper{r: http.DefaultTransport, ocr: ocr},
+	}
+}
+
+// do runs fn using a Tika server from the pool. Transient errors are retried with exponential backoff (on another
+// server, if available). When no server is healthy, do blocks until one recovers.
+func (p *tikaPool) do(ocr ocrSettings, fn func(client *tika.Client) error) error {
+	return p.run(ocr, true, fn)
+}
+
+// tryDo is like do, but returns errNoHealthyTikaServers instead of blocking when no server is healthy.
+func (p *tikaPool) tryDo(ocr ocrSettings, fn func(client *tika.Client) error) error {
+	return p.run(ocr, false, fn)
+}
+
+func (p *tikaPool) run(ocr ocrSettings, wait bool, fn func(client *tika.Client) error) error {
+	for attempt := 0; ; {
+		server, err := p.acquire()
+		if err == errNoHealthyTikaServers {
+			if !wait {
+				return err
+			}
+			p.waitUntilHealthy()
+			continue
+		}
+
+		err = fn(tika.NewClient(p.httpClient(ocr), server.endpoint))
+		p.release(server, err)
+		if err == nil || attempt >= p.config.MaxRetries || !isTransientTikaError(err) {
+			return err
+		}
+
+		p.logger.Warnf("Tika request to %s failed, retrying (%d/%d): %v", server.endpoint, attempt+1, p.config.MaxRetries, err)
+		time.Sleep(p.config.RetryBackoff << uint(attempt))
+		attempt++
+	}
+}
+
+// acquire selects a healthy server, using the configured balancing strategy. Unhealthy servers are health checked
+// again once the health check interval has passed.
+func (p *tikaPool) acquire() (*tikaServer, error) {
+	p.recheckUnhealthyServers()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var selected *tikaServer
+	for i := range p.servers {
+		server := p.servers[(p.next+i)%len(p.servers)]
+		if !server.healthy {
+			continue
+		}
+		if p.config.Balancing == tikaBalancingRoundRobin {
+			p.next = (p.next + i + 1) % len(p.servers)
+			selected = server
+			break
+		}
+		if selected == nil || server.inFlight < selected.inFlight {
+			selected = server
+		}
+	}
+	if selected == nil {
+		return nil, errNoHealthyTikaServers
+	}
+	selected.inFlight++
+	return selected, nil
+}
+
+// release returns a server to the pool, marking it unhealthy if the request failed because the server is unavailable.
+func (p *tikaPool) release(server *tikaServer, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	server.inFlight--
+	if err != nil && isTransientTikaError(err) && server.healthy {
+		p.logger.Warnf("Tika server %s is unavailable: %v", server.endpoint, err)
+		server.healthy = false
+		server.lastChecked = time.Now()
+	}
+}
+
+// waitUntilHealthy blocks until at least one Tika server is healthy.
+func (p *tikaPool) waitUntilHealthy() {
+	if p.healthyServers() > 0 {
+		return
+	}
+
+	p.logger.Warnln("No healthy Tika servers available, pausing document processing")
+	for p.checkHealth() == 0 {
+		time.Sleep(p.config.HealthCheckInterval)
+	}
+	p.logger.Infoln("Tika server available, resuming document processing")
+}
+
+// checkHealth checks every server, and returns the number of healthy servers.
+func (p *tikaPool) checkHealth() int {
+	for _, server := range p.servers {
+		p.checkServer(server)
+	}
+	return p.healthyServers()
+}
+
+func (p *tikaPool) recheckUnhealthyServers() {
+	p.mutex.Lock()
+	stale := []*tikaServer{}
+	for _, server := range p.servers {
+		if !server.healthy && time.Since(server.lastChecked) >= p.config.HealthCheckInterval {
+			stale = append(stale, server)
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, server := range stale {
+		p.checkServer(server)
+	}
+}
+
+func (p *tikaPool) checkServer(server *tikaServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), tikaHealthCheckTimeout)
+	defer cancel()
+	_, err := tika.NewClient(&http.Client{}, server.endpoint).Version(ctx)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.logger.Debugf("Tika server %s failed health check: %v", server.endpoint, err)
+	} else if !server.healthy {
+		p.logger.Infof("Tika server %s is healthy", server.endpoint)
+	}
+	server.healthy = err == nil
+	server.lastChecked = time.Now()
+}
+
+func (p *tikaPool) healthyServers() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	healthy := 0
+	for _, server := range p.servers {
+		if server.healthy {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// isTransientTikaError determines if a request failed because the Tika server is (temporarily) unavailable, rather
+// than because the document could not be parsed. Timeouts are not retried, the document is likely too complex.
+func isTransientTikaError(err error) bool {
+	if err == errNoHealthyTikaServers {
+		return true
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !urlErr.Timeout()
+	}
+
+	//go-tika does not expose the response status code
+	var status int
+	if _, scanErr := fmt.Sscanf(err.Error(), "response code %d", &status); scanErr == nil {
+		return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-tika/tika"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTikaPool(t *testing.T, endpoints ...string) *tikaPool {
+	pool, err := newTikaPool(TikaConfig{
+		Endpoints:           endpoints,
+		MaxRetries:          2,
+		RetryBackoff:        time.Millisecond,
+		HealthCheckInterval: time.Millisecond,
+	}, logrus.WithField("test", t.Name()))
+	require.NoError(t, err)
+	return pool
+}
+
+func TestTikaConfig_Validate(t *testing.T) {
+	require.NoError(t, TikaConfig{Endpoints: []string{"http://tika:9998"}}.Validate())
+	require.NoError(t, TikaConfig{Endpoints: []string{"http://tika1:9998", "http://tika2:9998"}, Balancing: tikaBalancingLeastBusy}.Validate())
+	require.Error(t, TikaConfig{}.Validate())
+	require.Error(t, TikaConfig{Endpoints: []string{"tika"}}.Validate())
+	require.Error(t, TikaConfig{Endpoints: []string{"http://tika:9998"}, Balancing: "random"}.Validate())
+	require.Error(t, TikaConfig{Endpoints: []string{"http://tika:9998"}, MaxRetries: -1}.Validate())
+}
+
+func TestTikaPool_Acquire_RoundRobin(t *testing.T) {
+	//setup
+	pool := newTestTikaPool(t, "http://tika1:9998", "http://tika2:9998", "http://tika3:9998")
+	pool.servers[1].healthy = false
+	pool.servers[1].lastChecked = time.Now().Add(time.Hour) //do not recheck
+
+	//test
+	endpoints := []string{}
+	for i := 0; i < 4; i++ {
+		server, err := pool.acquire()
+		require.NoError(t, err)
+		pool.release(server, nil)
+		endpoints = append(endpoints, server.endpoint)
+	}
+
+	//assert
+	require.Equal(t, []string{"http://tika1:9998", "http://tika3:9998", "http://tika1:9998", "http://tika3:9998"}, endpoints)
+}
+
+func TestTikaPool_Acquire_LeastBusy(t *testing.T) {
+	//setup
+	pool := newTestTikaPool(t, "http://tika1:9998", "http://tika2:9998", "http://tika3:9998")
+	pool.config.Balancing = tikaBalancingLeastBusy
+	pool.servers[0].inFlight = 2
+	pool.servers[2].inFlight = 1
+
+	//test & assert
+	first, err := pool.acquire()
+	require.NoError(t, err)
+	require.Equal(t, "http://tika2:9998", first.endpoint)
+
+	second, err := pool.acquire()
+	require.NoError(t, err)
+	require.Equal(t, "http://tika2:9998", second.endpoint, "tika2 & tika3 are equally busy, the first is selected")
+
+	third, err := pool.acquire()
+	require.NoError(t, err)
+	require.Equal(t, "http://tika3:9998", third.endpoint)
+}
+
+func TestTikaPool_Do_RetriesUnavailableServer(t *testing.T) {
+	//setup
+	unavailableServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailableServer.Close()
+	healthyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Apache Tika 1.22")
+	}))
+	defer healthyServer.Close()
+	pool := newTestTikaPool(t, unavailableServer.URL, healthyServer.URL)
+	pool.config.HealthCheckInterval = time.Hour
+
+	//test
+	var version string
+	err := pool.do(ocrSettings{}, func(client *tika.Client) error {
+		var err error
+		version, err = client.Version(context.Background())
+		return err
+	})
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, "Apache Tika 1.22", version)
+	require.False(t, pool.servers[0].healthy)
+	require.True(t, pool.servers[1].healthy)
+}
+
+func TestTikaPool_Do_DoesNotRetryParseErrors(t *testing.T) {
+	//setup
+	requests := 0
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer tikaServer.Close()
+	pool := newTestTikaPool(t, tikaServer.URL)
+
+	//test
+	err := pool.do(ocrSettings{}, func(client *tika.Client) error {
+		_, err := client.Version(context.Background())
+		return err
+	})
+
+	//assert
+	require.EqualError(t, err, "response code 422")
+	require.Equal(t, 1, requests)
+	require.True(t, pool.servers[0].healthy)
+}
+
+func TestTikaPool_Do_WaitsForHealthyServer(t *testing.T) {
+	//setup
+	var available int32
+	tikaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&available) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "Apache Tika 1.22")
+	}))
+	defer tikaServer.Close()
+	pool := newTestTikaPool(t, tikaServer.URL)
+	pool.servers[0].healthy = false
+	time.AfterFunc(50*time.Millisecond, func() { atomic.StoreInt32(&available, 1) })
+
+	//test
+	err := pool.do(ocrSettings{}, func(client *tika.Client) error {
+		_, err := client.Version(context.Background())
+		return err
+	})
+
+	//assert
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&available))
+	require.True(t, pool.servers[0].healthy)
+}
+
+func TestIsTransientTikaError(t *testing.T) {
+	var tests = []struct {
+		err      error
+		expected bool
+	}{
+		{errNoHealthyTikaServers, true},
+		{&url.Error{Op: "Put", URL: "http://tika:9998/rmeta/xml", Err: errors.New("connection refused")}, true},
+		{errors.New("response code 503"), true},
+		{errors.New("response code 502"), true},
+		{errors.New("response code 422"), false},
+		{errors.New("response code 500"), false},
+		{errors.New("invalid character '<' looking for beginning of value"), false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, isTransientTikaError(tt.err), "error: %v", tt.err)
+	}
+}
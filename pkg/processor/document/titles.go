@@ -0,0 +1,139 @@
+package document
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+)
+
+// sources of generated titles, stored in lodestone.title_source
+const (
+	titleSourceMeta     = "meta"     //meta.title
+	titleSourceContent  = "content"  //first heading or line of the content
+	titleSourceFilename = "filename" //cleaned up file name
+)
+
+const (
+	// maximum length (in characters) of a generated title
+	maxTitleChars = 100
+
+	// number of content lines searched for a title
+	maxTitleLines = 10
+)
+
+var (
+	// prefixes added by office applications when printing to PDF, eg. "Microsoft Word - Report.docx"
+	titleApplicationPrefixPattern = regexp.MustCompile(`(?i)^(?:microsoft\s+(?:word|excel|powerpoint|office\s+\w+)|word|excel|powerpoint)\s+-\s+`)
+
+	// placeholder titles, eg. "Document1", "Untitled", "Slide 1", "PowerPoint Presentation"
+	titleJunkPattern = regexp.MustCompile(`(?i)^(?:untitled(?:\s+document)?|document\s*\d*|doc\d*|book\s*\d*|sheet\s*\d*|slide\s*\d*|presentation\d*|powerpoint\s+presentation|title|no\s+title|new\s+document|microsoft\s+word\s+document|pdf|scan|scanned\s+document|image|img|page\s*\d*)$`)
+
+	// file names used as titles, eg. "report.docx" or "C:\Users\john\report.docx"
+	titleFileNamePattern = regexp.MustCompile(`(?i)(?:^[a-z]:\\|[\\/]|\.(?:docx?|xlsx?|pptx?|pdf|odt|ods|odp|rtf|txt|html?|tiff?|jpe?g|png|msg|eml)$)`)
+
+	// markdown heading markers and list bullets at the start of a line
+	titleLinePrefixPattern = regexp.MustCompile(`^(?:#{1,6}\s+|[-*•]\s+)`)
+)
+
+// generateTitle returns a display title for the document and its source: the metadata title (unless it is a
+// placeholder, like "Microsoft Word - Document1"), the first heading or line of the content, or the cleaned up file
+// name.
+func generateTitle(doc model.Document) (string, string) {
+	if title := cleanMetaTitle(doc.Meta.Title); title != "" {
+		return title, titleSourceMeta
+	}
+	if title := contentTitle(doc.Content); title != "" {
+		return title, titleSourceContent
+	}
+	if title := fileNameTitle(doc.File.FileName); title != "" {
+		return title, titleSourceFilename
+	}
+	return "", ""
+}
+
+// cleanMetaTitle removes application prefixes from the metadata title, and returns "" for placeholder titles
+func cleanMetaTitle(title string) string {
+	title = strings.TrimSpace(collapseWhitespace(title))
+	title = titleApplicationPrefixPattern.ReplaceAllString(title, "")
+	if titleFileNamePattern.MatchString(title) {
+		//"Microsoft Word - Annual Report.docx" is a usable title, "C:\Users\john\Document1.doc" is not
+		title = fileNameTitle(title)
+	}
+	if !isTitle(title) {
+		return ""
+	}
+	return shortenTitle(title)
+}
+
+// contentTitle returns the first line of the content that looks like a title
+func contentTitle(content string) string {
+	lines := 0
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(collapseWhitespace(titleLinePrefixPattern.ReplaceAllString(strings.TrimSpace(line), "")))
+		if line == "" {
+			continue
+		}
+		if lines++; lines > maxTitleLines {
+			break
+		}
+		//long lines are paragraphs, not headings
+		if len([]rune(line)) <= maxTitleChars && isTitle(line) {
+			return line
+		}
+	}
+	return ""
+}
+
+// fileNameTitle removes the extension and separators from a file name, eg. "2019-04-15_acme_invoice.pdf" becomes
+// "2019-04-15 acme invoice"
+func fileNameTitle(fileName string) string {
+	fileName = fileName[strings.LastIndexAny(fileName, `/\`)+1:]
+	fileName = strings.TrimSuffix(fileName, path.Ext(fileName))
+
+	//"-" and "." are kept between digits (dates, version numbers)
+	runes := []rune(fileName)
+	var cleaned strings.Builder
+	for i, char := range runes {
+		betweenDigits := i > 0 && i < len(runes)-1 && unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1])
+		if char == '_' || char == '+' || ((char == '-' || char == '.') && !betweenDigits) {
+			cleaned.WriteRune(' ')
+		} else {
+			cleaned.WriteRune(char)
+		}
+	}
+
+	title := strings.TrimSpace(collapseWhitespace(cleaned.String()))
+	if !isTitle(title) {
+		return ""
+	}
+	return shortenTitle(title)
+}
+
+// isTitle returns false for empty and placeholder titles, and for titles without letters (eg. page numbers)
+func isTitle(title string) bool {
+	if title == "" || titleJunkPattern.MatchString(title) {
+		return false
+	}
+	letters := 0
+	for _, char := range title {
+		if unicode.IsLetter(char) {
+			letters++
+		}
+	}
+	return letters >= 2
+}
+
+// shortenTitle truncates titles longer than maxTitleChars at a word boundary
+func shortenTitle(title string) string {
+	shortened, truncated := truncateText(title, maxTitleChars)
+	if !truncated {
+		return title
+	}
+	if space := strings.LastIndex(shortened, " "); space > 0 {
+		shortened = shortened[:space]
+	}
+	return strings.TrimRight(shortened, " ,;:-") + "…"
+}
@@ -0,0 +1,75 @@
+package document
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTitle(t *testing.T) {
+	var tests = []struct {
+		metaTitle string
+		content   string
+		fileName  string
+		title     string
+		source    string
+	}{
+		{"Annual Report 2019", "Contents", "report.pdf", "Annual Report 2019", titleSourceMeta},
+		{"Microsoft Word - Annual Report.docx", "", "report.pdf", "Annual Report", titleSourceMeta},
+		{"Microsoft Word - Document1", "\n\n  # Quarterly   Results\nRevenue was up.", "report.pdf", "Quarterly Results", titleSourceContent},
+		{"Untitled", "1\n\n" + strings.Repeat("A very long first paragraph. ", 10) + "\nSummary", "report.pdf", "Summary", titleSourceContent},
+		{`C:\Users\john\Document1.doc`, "", "2019-04-15_acme_invoice.v1.2.pdf", "2019-04-15 acme invoice v1.2", titleSourceFilename},
+		{"", "- 12 -", "scan_0001.tiff", "scan 0001", titleSourceFilename},
+		{"", "", "Scan.pdf", "", ""},
+	}
+
+	for _, test := range tests {
+		//setup
+		doc := model.Document{
+			Content: test.content,
+			Meta:    model.DocMeta{Title: test.metaTitle},
+			File:    model.DocFile{FileName: test.fileName},
+		}
+
+		//test
+		title, source := generateTitle(doc)
+
+		//assert
+		require.Equal(t, test.title, title, test.metaTitle)
+		require.Equal(t, test.source, source, test.metaTitle)
+	}
+}
+
+func TestGenerateTitle_Shortened(t *testing.T) {
+	title, source := generateTitle(model.Document{Meta: model.DocMeta{Title: strings.Repeat("lorem ipsum, ", 20)}})
+	require.Equal(t, titleSourceMeta, source)
+	require.Equal(t, strings.Repeat("lorem ipsum, ", 7)+"lorem…", title)
+}
+
+func TestMergeLodestone_Title(t *testing.T) {
+	generated := model.DocLodestone{Title: "Annual Report", TitleSource: titleSourceMeta}
+
+	//titles set by the user are never replaced
+	merged := mergeLodestone(model.DocLodestone{Title: "My Report"}, generated)
+	require.Equal(t, "My Report", merged.Title)
+	require.Equal(t, "", merged.TitleSource)
+	require.Equal(t, "Annual Report", merged.GeneratedTitle)
+
+	//the webapp keeps the source when the user changes a generated title
+	merged = mergeLodestone(model.DocLodestone{Title: "My Report", TitleSource: titleSourceFilename, GeneratedTitle: "annual_report"}, generated)
+	require.Equal(t, "My Report", merged.Title)
+	require.Equal(t, "", merged.TitleSource)
+
+	merged = mergeLodestone(model.DocLodestone{}, generated)
+	require.Equal(t, "Annual Report", merged.Title)
+	require.Equal(t, titleSourceMeta, merged.TitleSource)
+	require.Equal(t, "Annual Report", merged.GeneratedTitle)
+
+	//generated titles are regenerated
+	merged = mergeLodestone(model.DocLodestone{Title: "annual_report", TitleSource: titleSourceFilename, GeneratedTitle: "annual_report"}, generated)
+	require.Equal(t, "Annual Report", merged.Title)
+	require.Equal(t, titleSourceMeta, merged.TitleSource)
+	require.Equal(t, "Annual Report", merged.GeneratedTitle)
+}
@@ -1,141 +1,110 @@
 package document
 
 import (
-	"fmt"
-	"log"
+	"path"
 	"reflect"
 	"strings"
 	"time"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
 )
 
-func castToTime(val interface{}) time.Time {
+//func stringHasValue(str string) bool {
+//	return str != ""
+//}
 
-	rt := reflect.TypeOf(val)
-	if rt == nil {
-		return time.Time{}
-	}
-	switch rt.Kind() {
-	case reflect.Slice:
-		valSlice, ok := val.([]string)
-		if !ok {
-			log.Printf("%v is not a []string", val)
-			return time.Time{}
+func deleteEmpty(s []string) []string {
+	var r []string
+	for _, str := range s {
+		if str != "" {
+			r = append(r, str)
 		}
+	}
+	return r
+}
 
-		t, err := time.Parse(time.RFC3339, valSlice[0])
-		if err != nil {
-			return time.Time{}
-		}
-		return t
+// the lodestone section of a document is owned by the user (webapp). Keep any values the user has set, and merge in the
+// values generated by the processor.
+func mergeLodestone(existing model.DocLodestone, generated model.DocLodestone) model.DocLodestone {
+	merged := existing
+	merged.ProcessorVersion = generated.ProcessorVersion
+	merged.AutoKeywords = generated.AutoKeywords
 
-	case reflect.Array:
-		valSlice, ok := val.([]string)
-		if !ok {
-			log.Printf("%v is not an array", val)
-			return time.Time{}
-		}
-		t, err := time.Parse(time.RFC3339, valSlice[0])
-		if err != nil {
-			return time.Time{}
-		}
-		return t
+	//titles that still match the last generated title are regenerated, titles changed by the user are never replaced.
+	if merged.Title == "" || merged.Title == existing.GeneratedTitle {
+		merged.Title = generated.Title
+		merged.TitleSource = generated.TitleSource
+	} else {
+		merged.TitleSource = ""
+	}
+	merged.GeneratedTitle = generated.Title
 
-	case reflect.String:
-		valStr, ok := val.(string)
-		if !ok {
-			log.Printf("%v is not a string", val)
-			return time.Time{}
-		}
+	//categories that still match the last generated category are reclassified, categories changed by the user are never
+	//replaced.
+	if merged.Category == "" || merged.Category == existing.GeneratedCategory {
+		merged.Category = generated.Category
+		merged.CategorySource = generated.CategorySource
+		merged.CategoryConfidence = generated.CategoryConfidence
+	} else {
+		merged.CategorySource = ""
+		merged.CategoryConfidence = 0
+	}
+	merged.GeneratedCategory = generated.Category
 
-		t, err := time.Parse(time.RFC3339, valStr)
-		if err != nil {
-			return time.Time{}
+	//only new processor tags are added, tags the user removed stay removed
+	merged.Tags = existing.Tags
+	for _, tag := range generated.Tags {
+		if !containsString(merged.Tags, tag) && !containsString(existing.GeneratedTags, tag) {
+			merged.Tags = append(merged.Tags, tag)
 		}
-		return t
-
-	default:
-		return time.Time{}
 	}
-
-	return time.Time{}
+	merged.GeneratedTags = generated.Tags
+	return merged
 }
 
-func castToString(val interface{}) string {
-	rt := reflect.TypeOf(val)
-	if rt == nil {
-		return ""
-	}
-	switch rt.Kind() {
-	case reflect.Slice:
-		valSlice, ok := val.([]string)
-		if !ok {
-			log.Printf("%v is not a []string", val)
-			return ""
-		}
-		return fmt.Sprintf(strings.Join(valSlice, ", "))
-	case reflect.Array:
-		valSlice, ok := val.([]string)
-		if !ok {
-			log.Printf("%v is not an array", val)
-			return ""
-		}
-		return fmt.Sprintf(strings.Join(valSlice, ", "))
-	case reflect.String:
-		valStr, ok := val.(string)
-		if !ok {
-			log.Printf("%v is not a string", val)
-			return ""
-		}
-		return valStr
-	default:
-		return ""
+// optionalTime returns nil for the zero time, so unknown dates are not indexed
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
 	}
+	return &t
 }
 
-func castToStringArray(val interface{}) []string {
-	rt := reflect.TypeOf(val)
-	if rt == nil {
-		return []string{}
+func containsString(list []string, item string) bool {
+	for _, str := range list {
+		if str == item {
+			return true
+		}
 	}
+	return false
+}
 
-	switch rt.Kind() {
-	case reflect.Slice:
-		valSlice, ok := val.([]string)
-		if !ok {
-			log.Printf("%v is not a []string", val)
-			return []string{}
-		}
-		return valSlice
-	case reflect.Array:
-		valSlice, ok := val.([]string)
-		if !ok {
-			log.Printf("%v is not a []string", val)
-			return []string{}
-		}
-		return valSlice
-	case reflect.String:
-		valStr, ok := val.(string)
-		if !ok {
-			log.Printf("%v is not a string", val)
-			return []string{}
-		}
+// storageFolder returns the folder containing a storage path (eg. "taxes/2019" for "taxes/2019/form.pdf"), without
+// leading or trailing slashes. The folder of a file in the bucket root is "".
+func storageFolder(storagePath string) string {
+	return strings.TrimPrefix(path.Dir(path.Clean("/"+storagePath)), "/")
+}
 
-		return []string{valStr}
-	default:
-		return nil
+// folderTree returns the folder and its ancestors, starting with the top level folder (eg. "taxes", "taxes/2019" for
+// "taxes/2019"). Documents are aggregated & filtered by folder using storage.folder_tree.
+func folderTree(folder string) []string {
+	var tree []string
+	for _, folder := range newFolders("", folder) {
+		tree = append(tree, folder.Real)
 	}
+	return tree
 }
 
-//func stringHasValue(str string) bool {
-//	return str != ""
-//}
+// json names of the lodestone fields modelled by model.DocLodestone
+var lodestoneFields = jsonFieldNames(reflect.TypeOf(model.DocLodestone{}))
 
-func deleteEmpty(s []string) []string {
-	var r []string
-	for _, str := range s {
-		if str != "" {
-			r = append(r, str)
+func jsonFieldNames(structType reflect.Type) []string {
+	var names []string
+	for i := 0; i < structType.NumField(); i++ {
+		name := strings.Split(structType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names = append(names, name)
 		}
 	}
-	return r
+	return names
 }
@@ -0,0 +1,58 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/analogj/lodestone-processor/pkg/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageFolder(t *testing.T) {
+	var tests = []struct {
+		storagePath string
+		expected    string
+	}{
+		{"taxes/2019/receipts/form.pdf", "taxes/2019/receipts"},
+		{"/taxes//2019/form.pdf", "taxes/2019"},
+		{"form.pdf", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, storageFolder(tt.storagePath), "path: %s", tt.storagePath)
+	}
+}
+
+func TestFolderTree(t *testing.T) {
+	require.Equal(t, []string{"taxes", "taxes/2019", "taxes/2019/receipts"}, folderTree("taxes/2019/receipts"))
+	require.Nil(t, folderTree(""))
+}
+
+func TestMergeLodestone_Category(t *testing.T) {
+	generated := model.DocLodestone{Category: "invoice", CategorySource: categorySourceRules, CategoryConfidence: 0.75}
+
+	//categories assigned by the user are kept
+	merged := mergeLodestone(model.DocLodestone{Category: "contract"}, generated)
+	require.Equal(t, "contract", merged.Category)
+	require.Equal(t, "", merged.CategorySource)
+	require.Equal(t, "invoice", merged.GeneratedCategory)
+
+	//the webapp keeps the source and confidence when the user changes a generated category
+	merged = mergeLodestone(model.DocLodestone{Category: "contract", CategorySource: categorySourceRules, CategoryConfidence: 0.5, GeneratedCategory: "receipt"}, generated)
+	require.Equal(t, "contract", merged.Category)
+	require.Equal(t, "", merged.CategorySource)
+	require.Equal(t, 0.0, merged.CategoryConfidence)
+
+	//categories assigned by the processor are reclassified
+	merged = mergeLodestone(model.DocLodestone{Category: "receipt", CategorySource: categorySourceRules, CategoryConfidence: 0.5, GeneratedCategory: "receipt"}, generated)
+	require.Equal(t, "invoice", merged.Category)
+	require.Equal(t, categorySourceRules, merged.CategorySource)
+	require.Equal(t, 0.75, merged.CategoryConfidence)
+	require.Equal(t, "invoice", merged.GeneratedCategory)
+
+	merged = mergeLodestone(model.DocLodestone{Category: "receipt", CategorySource: categorySourceRules, CategoryConfidence: 0.5, GeneratedCategory: "receipt"}, model.DocLodestone{})
+	require.Equal(t, "", merged.Category)
+	require.Equal(t, "", merged.CategorySource)
+	require.Equal(t, "", merged.GeneratedCategory)
+}